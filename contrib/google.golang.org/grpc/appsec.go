@@ -26,6 +26,7 @@ func appsecUnaryHandlerMiddleware(span ddtrace.Span, handler grpc.UnaryHandler)
 	return func(ctx context.Context, req interface{}) (interface{}, error) {
 		md, _ := metadata.FromIncomingContext(ctx)
 		clientIP := setClientIP(ctx, span, md)
+		grpcsec.SetRequestMetadataTags(span, md)
 		ctx, op := grpcsec.StartHandlerOperation(ctx, grpcsec.HandlerOperationArgs{Metadata: md, ClientIP: clientIP}, nil)
 		defer func() {
 			events := op.Finish(grpcsec.HandlerOperationRes{})
@@ -40,7 +41,7 @@ func appsecUnaryHandlerMiddleware(span ddtrace.Span, handler grpc.UnaryHandler)
 			return nil, op.Error
 		}
 
-		defer grpcsec.StartReceiveOperation(grpcsec.ReceiveOperationArgs{}, op).Finish(grpcsec.ReceiveOperationRes{Message: req})
+		defer grpcsec.StartReceiveOperation(grpcsec.ReceiveOperationArgs{}, op).Finish(grpcsec.ReceiveOperationRes{Message: req, MessageIndex: -1})
 		return handler(ctx, req)
 	}
 }
@@ -52,12 +53,14 @@ func appsecStreamHandlerMiddleware(span ddtrace.Span, handler grpc.StreamHandler
 		ctx := stream.Context()
 		md, _ := metadata.FromIncomingContext(ctx)
 		clientIP := setClientIP(ctx, span, md)
+		grpcsec.SetRequestMetadataTags(span, md)
 
 		ctx, op := grpcsec.StartHandlerOperation(ctx, grpcsec.HandlerOperationArgs{Metadata: md, ClientIP: clientIP}, nil)
 		stream = appsecServerStream{
 			ServerStream:     stream,
 			handlerOperation: op,
 			ctx:              ctx,
+			messages:         new(grpcsec.StreamMessageCounter),
 		}
 		defer func() {
 			events := op.Finish(grpcsec.HandlerOperationRes{})
@@ -65,7 +68,11 @@ func appsecStreamHandlerMiddleware(span ddtrace.Span, handler grpc.StreamHandler
 			if len(events) == 0 {
 				return
 			}
-			setAppSecEventsTags(stream.Context(), span, events)
+			var opts []grpcsec.SecurityEventTagsOption
+			if op.MessageIndex >= 0 {
+				opts = append(opts, grpcsec.WithMessageIndex(op.MessageIndex))
+			}
+			setAppSecEventsTags(stream.Context(), span, events, opts...)
 		}()
 
 		if op.Error != nil {
@@ -80,26 +87,41 @@ type appsecServerStream struct {
 	grpc.ServerStream
 	handlerOperation *grpcsec.HandlerOperation
 	ctx              context.Context
+	messages         *grpcsec.StreamMessageCounter
 }
 
 // RecvMsg implements grpc.ServerStream interface method to monitor its
 // execution with AppSec.
-func (ss appsecServerStream) RecvMsg(m interface{}) error {
+func (ss appsecServerStream) RecvMsg(m interface{}) (err error) {
 	op := grpcsec.StartReceiveOperation(grpcsec.ReceiveOperationArgs{}, ss.handlerOperation)
 	defer func() {
-		op.Finish(grpcsec.ReceiveOperationRes{Message: m})
+		index := -1
+		if err == nil {
+			index = ss.messages.Next()
+		}
+		op.Finish(grpcsec.ReceiveOperationRes{Message: m, MessageIndex: index})
 	}()
 	return ss.ServerStream.RecvMsg(m)
 }
 
+// SendMsg implements grpc.ServerStream interface method to count the sent
+// messages, the messages of the stream being indexed in both directions.
+func (ss appsecServerStream) SendMsg(m interface{}) error {
+	if err := ss.ServerStream.SendMsg(m); err != nil {
+		return err
+	}
+	ss.messages.Next()
+	return nil
+}
+
 func (ss appsecServerStream) Context() context.Context {
 	return ss.ctx
 }
 
 // Set the AppSec tags when security events were found.
-func setAppSecEventsTags(ctx context.Context, span ddtrace.Span, events []json.RawMessage) {
+func setAppSecEventsTags(ctx context.Context, span ddtrace.Span, events []json.RawMessage, opts ...grpcsec.SecurityEventTagsOption) {
 	md, _ := metadata.FromIncomingContext(ctx)
-	grpcsec.SetSecurityEventTags(span, events, md)
+	grpcsec.SetSecurityEventTags(span, events, md, opts...)
 }
 
 func setClientIP(ctx context.Context, span ddtrace.Span, md metadata.MD) netip.Addr {
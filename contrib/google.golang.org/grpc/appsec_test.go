@@ -7,14 +7,19 @@ package grpc
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"net"
 	"strings"
 	"testing"
 
 	pappsec "gopkg.in/DataDog/dd-trace-go.v1/appsec"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/mocktracer"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/appsec"
+	"gopkg.in/DataDog/dd-trace-go.v1/internal/appsec/dyngo"
+	"gopkg.in/DataDog/dd-trace-go.v1/internal/appsec/dyngo/instrumentation/grpcsec"
 
 	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc"
@@ -55,6 +60,7 @@ func TestAppSec(t *testing.T) {
 		require.NotNil(t, event)
 		require.True(t, strings.Contains(event, "crs-941-110")) // XSS attack attempt
 		require.True(t, strings.Contains(event, "ua0-600-55x")) // canary rule attack attempt
+		require.NotContains(t, finished[0].Tags(), "grpc.message_index")
 	})
 
 	t.Run("stream", func(t *testing.T) {
@@ -98,7 +104,130 @@ func TestAppSec(t *testing.T) {
 		require.True(t, strings.Contains(event, "crs-941-110")) // XSS attack attempt
 		require.True(t, strings.Contains(event, "crs-942-100")) // SQL-injection attack attempt
 		require.True(t, strings.Contains(event, "ua0-600-55x")) // canary rule attack attempt
+		// The SQLi attack was the last one, in the second received message,
+		// which is the third one of the stream after the first reply
+		require.Equal(t, 2, finished[5].Tag("grpc.message_index"))
 	})
+
+	t.Run("stream-message-index", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		stream, err := client.StreamPing(context.Background())
+		require.NoError(t, err)
+
+		// Send a XSS attack followed by a benign message, the received and
+		// sent messages being indexed
+		for _, name := range []string{"<script>evilJSCode;</script>", "hello"} {
+			err = stream.Send(&FixtureRequest{Name: name})
+			require.NoError(t, err)
+			res, err := stream.Recv()
+			require.NoError(t, err)
+			require.Equal(t, "passed", res.Message)
+		}
+
+		err = stream.CloseSend()
+		require.NoError(t, err)
+		// to flush the spans
+		stream.Recv()
+
+		finished := mt.FinishedSpans()
+		require.Len(t, finished, 6)
+
+		event, _ := finished[5].Tag("_dd.appsec.json").(string)
+		require.True(t, strings.Contains(event, "crs-941-110")) // XSS attack attempt
+		require.Equal(t, 0, finished[5].Tag("grpc.message_index"))
+	})
+}
+
+// Test that the index of the messages is passed down to the security event
+// listeners, without depending on the WAF being available.
+func TestAppSecMessageIndex(t *testing.T) {
+	// security events are observed on the messages containing "attack", as the
+	// WAF event listener does
+	root := dyngo.NewRootOperation()
+	root.On(grpcsec.OnHandlerOperationStart(func(handlerOp *grpcsec.HandlerOperation, _ grpcsec.HandlerOperationArgs) {
+		handlerOp.On(grpcsec.OnReceiveOperationStart(func(op grpcsec.ReceiveOperation, _ grpcsec.ReceiveOperationArgs) {
+			op.On(grpcsec.OnReceiveOperationFinish(func(_ grpcsec.ReceiveOperation, res grpcsec.ReceiveOperationRes) {
+				if req, ok := res.Message.(*FixtureRequest); ok && req.Name == "attack" {
+					handlerOp.AddSecurityEvents(json.RawMessage(`[{"rule":{"id":"attack"}}]`))
+					handlerOp.SetMessageIndex(res.MessageIndex)
+				}
+			}))
+		}))
+	}))
+	dyngo.SwapRootOperation(root)
+	defer dyngo.SwapRootOperation(nil)
+
+	t.Run("unary", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		span := tracer.StartSpan("grpc.server")
+		handler := appsecUnaryHandlerMiddleware(span, func(context.Context, interface{}) (interface{}, error) {
+			return &FixtureReply{Message: "passed"}, nil
+		})
+		_, err := handler(context.Background(), &FixtureRequest{Name: "attack"})
+		require.NoError(t, err)
+		span.Finish()
+
+		finished := mt.FinishedSpans()
+		require.Len(t, finished, 1)
+		require.NotNil(t, finished[0].Tag("_dd.appsec.json"))
+		require.NotContains(t, finished[0].Tags(), "grpc.message_index")
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		// the third message of the stream is the second received one, after
+		// the reply to the first one
+		span := tracer.StartSpan("grpc.server")
+		stream := &messageStream{ctx: context.Background(), names: []string{"hello", "attack", "hello"}}
+		handler := appsecStreamHandlerMiddleware(span, func(_ interface{}, stream grpc.ServerStream) error {
+			for {
+				var req FixtureRequest
+				if err := stream.RecvMsg(&req); err != nil {
+					return nil
+				}
+				if err := stream.SendMsg(&FixtureReply{Message: "passed"}); err != nil {
+					return err
+				}
+			}
+		})
+		require.NoError(t, handler(nil, stream))
+		span.Finish()
+		require.Equal(t, 3, stream.sent)
+
+		finished := mt.FinishedSpans()
+		require.Len(t, finished, 1)
+		require.NotNil(t, finished[0].Tag("_dd.appsec.json"))
+		require.Equal(t, 2, finished[0].Tag("grpc.message_index"))
+	})
+}
+
+// messageStream is a server stream receiving requests with the given names.
+type messageStream struct {
+	grpc.ServerStream
+	ctx   context.Context
+	names []string
+	sent  int
+}
+
+func (s *messageStream) Context() context.Context { return s.ctx }
+
+func (s *messageStream) RecvMsg(m interface{}) error {
+	if len(s.names) == 0 {
+		return io.EOF
+	}
+	m.(*FixtureRequest).Name, s.names = s.names[0], s.names[1:]
+	return nil
+}
+
+func (s *messageStream) SendMsg(interface{}) error {
+	s.sent++
+	return nil
 }
 
 // Test that http blocking works by using custom rules/rules data
@@ -0,0 +1,168 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016 Datadog, Inc.
+
+package rueidis
+
+import (
+	"sync"
+	"time"
+
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+)
+
+// aggregator represents the commands with the same verb and parent span sent
+// within a time window by the span of the first of them, see WithAggregation.
+type aggregator struct {
+	window   time.Duration
+	maxCount int
+
+	mu     sync.Mutex
+	groups map[aggregateKey]*aggregateGroup
+}
+
+// aggregateKey identifies the commands which can be represented by the same
+// span: those with the same verb and the same parent span.
+type aggregateKey struct {
+	verb   string
+	parent uint64
+}
+
+// aggregateGroup is a group of commands with the same verb and parent span,
+// represented by the span of the first of them. The span is finished once the
+// group is closed and all its commands completed.
+type aggregateGroup struct {
+	span  *commandSpan
+	key   aggregateKey
+	count int
+	// pending is the number of commands of the group, other than the first one,
+	// which haven't completed yet.
+	pending int
+	// errors is the number of commands of the group, other than the first one,
+	// which failed, err being the first of their errors.
+	errors int
+	err    error
+	// closed is true once no more commands can join the group.
+	closed bool
+	// ended is true once the first command completed, with the options to
+	// finish the span with.
+	ended      bool
+	failed     bool
+	finishOpts []ddtrace.FinishOption
+}
+
+func newAggregator(window time.Duration, maxCount int) *aggregator {
+	return &aggregator{
+		window:   window,
+		maxCount: maxCount,
+		groups:   make(map[aggregateKey]*aggregateGroup),
+	}
+}
+
+// join adds a command with the given verb and parent span to their open group,
+// and returns it if there is one. The group is closed once it reaches the
+// maximum number of commands.
+func (a *aggregator) join(verb string, parent uint64) *aggregateGroup {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	g, ok := a.groups[aggregateKey{verb: verb, parent: parent}]
+	if !ok {
+		return nil
+	}
+	g.count++
+	g.pending++
+	if g.count >= a.maxCount {
+		a.close(g)
+	}
+	return g
+}
+
+// open opens a group of the commands with the same verb and parent span as the
+// one of span, represented by span, which is closed once the window elapsed
+// unless it is full before.
+func (a *aggregator) open(span *commandSpan, parent uint64) {
+	g := &aggregateGroup{span: span, key: aggregateKey{verb: span.verb, parent: parent}, count: 1}
+	span.group = g
+	a.mu.Lock()
+	a.groups[g.key] = g
+	a.mu.Unlock()
+	time.AfterFunc(a.window, func() {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		a.close(g)
+	})
+}
+
+// close closes g, finishing its span if all its commands completed. a.mu must
+// be held.
+func (a *aggregator) close(g *aggregateGroup) {
+	if g.closed {
+		return
+	}
+	g.closed = true
+	if a.groups[g.key] == g {
+		delete(a.groups, g.key)
+	}
+	g.finishIfDone()
+}
+
+// end records that the first command of g completed, failing or not, with the
+// options to finish its span with once g is closed and all its commands
+// completed.
+func (a *aggregator) end(g *aggregateGroup, failed bool, opts ...ddtrace.FinishOption) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	g.ended = true
+	g.failed = failed
+	g.finishOpts = opts
+	g.finishIfDone()
+}
+
+// leave records that a command of g other than the first one completed with
+// the given error, nil if it didn't fail.
+func (a *aggregator) leave(g *aggregateGroup, err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	g.pending--
+	if err != nil {
+		if g.errors == 0 {
+			g.err = err
+		}
+		g.errors++
+	}
+	g.finishIfDone()
+}
+
+// finishIfDone finishes the span of g once it is closed and all its commands
+// completed. The error of the first failed command is recorded on the span when
+// the first command didn't fail itself.
+func (g *aggregateGroup) finishIfDone() {
+	if !g.closed || !g.ended || g.pending > 0 {
+		return
+	}
+	g.span.SetTag(TagAggregatedCount, g.count)
+	opts := g.finishOpts
+	if g.errors > 0 {
+		g.span.SetTag(TagAggregatedErrors, g.errors)
+		if !g.failed {
+			opts = append(opts, tracer.WithError(g.err))
+		}
+	}
+	g.span.Finish(opts...)
+}
+
+// followerSpan is the span of a command represented by the span of the first
+// command of its group, see WithAggregation. Its tags are discarded and it is
+// never finished, its context being the one of the span of the group.
+type followerSpan struct {
+	group *aggregateGroup
+}
+
+func (followerSpan) SetTag(_ string, _ interface{})   {}
+func (followerSpan) SetOperationName(_ string)        {}
+func (followerSpan) BaggageItem(_ string) string      { return "" }
+func (followerSpan) SetBaggageItem(_, _ string)       {}
+func (followerSpan) Finish(_ ...ddtrace.FinishOption) {}
+func (s followerSpan) Context() ddtrace.SpanContext   { return s.group.span.Context() }
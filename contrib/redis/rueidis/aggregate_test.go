@@ -0,0 +1,176 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016 Datadog, Inc.
+
+package rueidis
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/mocktracer"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+
+	"github.com/golang/mock/gomock"
+	"github.com/redis/rueidis"
+	"github.com/redis/rueidis/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAggregation(t *testing.T) {
+	t.Run("max-count", func(t *testing.T) {
+		assert := assert.New(t)
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		mc := newMockClient(t)
+		mc.EXPECT().Do(gomock.Any(), gomock.Any()).Return(mock.Result(mock.RedisString("value"))).Times(6)
+		var stats HookStats
+		client := WrapClient(mc, WithAggregation(time.Hour, 4), WithHookStats(&stats))
+		parent, ctx := tracer.StartSpanFromContext(context.Background(), "parent")
+		defer parent.Finish()
+		for i := 0; i < 5; i++ {
+			client.Do(ctx, client.B().Get().Key("key").Build())
+		}
+		client.Do(ctx, client.B().Set().Key("key").Value("value").Build())
+
+		// the fifth GET and the SET are in open groups
+		spans := mt.FinishedSpans()
+		require.Len(t, spans, 1)
+		assert.Equal("GET", spans[0].Tag(ext.ResourceName))
+		assert.Equal(4, spans[0].Tag(TagAggregatedCount))
+		assert.Equal(int64(3), stats.SpansCreated())
+		assert.Equal(int64(3), stats.SpansSkipped())
+	})
+
+	t.Run("window", func(t *testing.T) {
+		assert := assert.New(t)
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		mc := newMockClient(t)
+		mc.EXPECT().Do(gomock.Any(), gomock.Any()).Return(mock.Result(mock.RedisString("value"))).Times(4)
+		client := WrapClient(mc, WithAggregation(50*time.Millisecond, 100))
+		parent, ctx := tracer.StartSpanFromContext(context.Background(), "parent")
+		defer parent.Finish()
+		for i := 0; i < 3; i++ {
+			client.Do(ctx, client.B().Get().Key("key").Build())
+		}
+		require.Eventually(t, func() bool { return len(mt.FinishedSpans()) == 1 }, time.Second, 10*time.Millisecond)
+		client.Do(ctx, client.B().Get().Key("key").Build())
+		require.Eventually(t, func() bool { return len(mt.FinishedSpans()) == 2 }, time.Second, 10*time.Millisecond)
+
+		spans := mt.FinishedSpans()
+		assert.Equal(3, spans[0].Tag(TagAggregatedCount))
+		assert.Equal(1, spans[1].Tag(TagAggregatedCount))
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		assert := assert.New(t)
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		mc := newMockClient(t)
+		mc.EXPECT().Do(gomock.Any(), gomock.Any()).Return(mock.Result(mock.RedisString("value")))
+		mc.EXPECT().Do(gomock.Any(), gomock.Any()).Return(mock.ErrorResult(errors.New("oops")))
+		mc.EXPECT().Do(gomock.Any(), gomock.Any()).Return(mock.ErrorResult(errors.New("again")))
+		var (
+			statsd   statsdRecorder
+			sunk     []error
+			finished []string
+		)
+		client := WrapClient(mc,
+			WithAggregation(time.Hour, 3),
+			WithStatsd(&statsd),
+			WithErrorSink(func(_ string, err error) { sunk = append(sunk, err) }),
+			WithFinishHook(func(verb string, _ time.Duration, _ error) { finished = append(finished, verb) }),
+		)
+		parent, ctx := tracer.StartSpanFromContext(context.Background(), "parent")
+		defer parent.Finish()
+		for i := 0; i < 3; i++ {
+			client.Do(ctx, client.B().Get().Key("key").Build())
+		}
+
+		spans := mt.FinishedSpans()
+		require.Len(t, spans, 1)
+		assert.Equal(3, spans[0].Tag(TagAggregatedCount))
+		assert.Equal(2, spans[0].Tag(TagAggregatedErrors))
+		assert.EqualError(spans[0].Tag(ext.Error).(error), "oops")
+		assert.Len(statsd.timings, 3)
+		assert.Equal([]string{"GET", "GET", "GET"}, finished)
+		require.Len(t, sunk, 2)
+		assert.EqualError(sunk[0], "oops")
+		assert.EqualError(sunk[1], "again")
+	})
+
+	t.Run("pending", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		mc := newMockClient(t)
+		mc.EXPECT().Do(gomock.Any(), gomock.Any()).Return(mock.Result(mock.RedisString("value")))
+		release := make(chan struct{})
+		mc.EXPECT().Do(gomock.Any(), gomock.Any()).DoAndReturn(func(context.Context, rueidis.Completed) rueidis.RedisResult {
+			<-release
+			return mock.ErrorResult(errors.New("oops"))
+		})
+		client := WrapClient(mc, WithAggregation(time.Hour, 2))
+		parent, ctx := tracer.StartSpanFromContext(context.Background(), "parent")
+		defer parent.Finish()
+		client.Do(ctx, client.B().Get().Key("key").Build())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			client.Do(ctx, client.B().Get().Key("key").Build())
+		}()
+
+		// the group is full, but its second command hasn't completed yet
+		require.Never(t, func() bool { return len(mt.FinishedSpans()) > 0 }, 50*time.Millisecond, 10*time.Millisecond)
+		close(release)
+		<-done
+		spans := mt.FinishedSpans()
+		require.Len(t, spans, 1)
+		assert.Equal(t, 1, spans[0].Tag(TagAggregatedErrors))
+	})
+
+	t.Run("parents", func(t *testing.T) {
+		assert := assert.New(t)
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		mc := newMockClient(t)
+		mc.EXPECT().Do(gomock.Any(), gomock.Any()).Return(mock.Result(mock.RedisString("value"))).Times(6)
+		client := WrapClient(mc, WithAggregation(time.Hour, 2))
+		first, firstCtx := tracer.StartSpanFromContext(context.Background(), "first")
+		second, secondCtx := tracer.StartSpanFromContext(context.Background(), "second")
+		// each trace gets its own group
+		client.Do(firstCtx, client.B().Get().Key("key").Build())
+		client.Do(secondCtx, client.B().Get().Key("key").Build())
+		client.Do(firstCtx, client.B().Get().Key("key").Build())
+		client.Do(secondCtx, client.B().Get().Key("key").Build())
+		// the commands without a parent span are not aggregated
+		client.Do(context.Background(), client.B().Get().Key("key").Build())
+		client.Do(context.Background(), client.B().Get().Key("key").Build())
+		first.Finish()
+		second.Finish()
+
+		var redisSpans []mocktracer.Span
+		for _, s := range mt.FinishedSpans() {
+			if s.OperationName() == "redis.command" {
+				redisSpans = append(redisSpans, s)
+			}
+		}
+		require.Len(t, redisSpans, 4)
+		assert.Equal(first.Context().SpanID(), redisSpans[0].ParentID())
+		assert.Equal(2, redisSpans[0].Tag(TagAggregatedCount))
+		assert.Equal(second.Context().SpanID(), redisSpans[1].ParentID())
+		assert.Equal(2, redisSpans[1].Tag(TagAggregatedCount))
+		assert.NotContains(redisSpans[2].Tags(), TagAggregatedCount)
+		assert.NotContains(redisSpans[3].Tags(), TagAggregatedCount)
+	})
+}
@@ -0,0 +1,89 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016 Datadog, Inc.
+
+package rueidis
+
+import (
+	"sync"
+	"time"
+
+	"github.com/redis/rueidis"
+)
+
+// CommandRecord describes a command sent through a traced client.
+type CommandRecord struct {
+	// Verb is the verb of the command, or "redis.pipeline" for the commands
+	// sent together with DoMulti or DoMultiCache.
+	Verb string
+	// Duration is how long the command took.
+	Duration time.Duration
+	// Err is the error the command failed with, or nil.
+	Err error
+}
+
+// DebugRingBuffer is implemented by the clients traced with WithDebugRingBuffer,
+// which record the last commands sent through them, for debugging purposes:
+//
+//	client, _ := rueidistrace.NewClient(option, rueidistrace.WithDebugRingBuffer(100))
+//	// ...
+//	cmds := client.(rueidistrace.DebugRingBuffer).DebugCommands()
+type DebugRingBuffer interface {
+	// DebugCommands returns the last commands sent, from the oldest to the
+	// most recent.
+	DebugCommands() []CommandRecord
+}
+
+// debugClient is a rueidis.Client recording its last traced commands in ring.
+type debugClient struct {
+	rueidis.Client
+	ring *commandRing
+}
+
+func (c *debugClient) DebugCommands() []CommandRecord {
+	return c.ring.commands()
+}
+
+// commandRing holds the last commands sent through a traced client. It is safe
+// for concurrent use.
+type commandRing struct {
+	mu      sync.Mutex
+	records []CommandRecord
+	next    int  // index of the next record to overwrite
+	full    bool // whether all the records are set
+}
+
+// newCommandRing returns a commandRing holding the last n commands. n must be
+// positive.
+func newCommandRing(n int) *commandRing {
+	return &commandRing{records: make([]CommandRecord, n)}
+}
+
+// commands returns the last commands sent, from the oldest to the most recent.
+func (r *commandRing) commands() []CommandRecord {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.full {
+		return append([]CommandRecord(nil), r.records[:r.next]...)
+	}
+	cmds := make([]CommandRecord, 0, len(r.records))
+	cmds = append(cmds, r.records[r.next:]...)
+	return append(cmds, r.records[:r.next]...)
+}
+
+// add records rec as the most recent command, overwriting the oldest one when r
+// is full. It does nothing if r is nil.
+func (r *commandRing) add(rec CommandRecord) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.records[r.next] = rec
+	r.next++
+	if r.next == len(r.records) {
+		r.next = 0
+		r.full = true
+	}
+}
@@ -0,0 +1,76 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016 Datadog, Inc.
+
+package rueidis
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/mocktracer"
+
+	"github.com/golang/mock/gomock"
+	"github.com/redis/rueidis"
+	"github.com/redis/rueidis/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommandRing(t *testing.T) {
+	r := newCommandRing(3)
+	assert.Empty(t, r.commands())
+	r.add(CommandRecord{Verb: "GET"})
+	r.add(CommandRecord{Verb: "SET"})
+	assert.Equal(t, []CommandRecord{{Verb: "GET"}, {Verb: "SET"}}, r.commands())
+	r.add(CommandRecord{Verb: "DEL"})
+	r.add(CommandRecord{Verb: "INCR"})
+	assert.Equal(t, []CommandRecord{{Verb: "SET"}, {Verb: "DEL"}, {Verb: "INCR"}}, r.commands())
+
+	assert.NotPanics(t, func() {
+		var r *commandRing
+		r.add(CommandRecord{Verb: "GET"})
+	})
+}
+
+func TestDebugRingBuffer(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	errTimeout := errors.New("timeout")
+	mc := newMockClient(t)
+	mc.EXPECT().Do(gomock.Any(), mock.Match("GET", "key")).Return(mock.Result(mock.RedisString("value")))
+	mc.EXPECT().Do(gomock.Any(), mock.Match("SET", "key", "value")).Return(mock.ErrorResult(errTimeout))
+	mc.EXPECT().Do(gomock.Any(), mock.Match("DEL", "key")).Return(mock.Result(mock.RedisInt64(1)))
+	mc.EXPECT().DoMulti(gomock.Any(), gomock.Any()).Return([]rueidis.RedisResult{
+		mock.Result(mock.RedisInt64(1)),
+	})
+	client := WrapClient(mc, WithDebugRingBuffer(3))
+	ctx := context.Background()
+	client.Do(ctx, client.B().Get().Key("key").Build())
+	client.Do(ctx, client.B().Set().Key("key").Value("value").Build())
+	client.Do(ctx, client.B().Del().Key("key").Build())
+	client.DoMulti(ctx, client.B().Incr().Key("counter").Build())
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 4)
+	ring, ok := client.(DebugRingBuffer)
+	require.True(t, ok)
+	cmds := ring.DebugCommands()
+	require.Len(t, cmds, 3)
+	for i, verb := range []string{"SET", "DEL", "redis.pipeline"} {
+		assert.Equal(t, verb, cmds[i].Verb)
+		assert.Equal(t, spans[i+1].FinishTime().Sub(spans[i+1].StartTime()), cmds[i].Duration)
+	}
+	assert.Equal(t, errTimeout, cmds[0].Err)
+	assert.NoError(t, cmds[1].Err)
+	assert.NoError(t, cmds[2].Err)
+
+	// the clients only record the commands with the option
+	_, ok = WrapClient(mc).(DebugRingBuffer)
+	assert.False(t, ok)
+	_, ok = WrapClient(mc, WithDebugRingBuffer(0)).(DebugRingBuffer)
+	assert.False(t, ok)
+}
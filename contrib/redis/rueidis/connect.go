@@ -0,0 +1,115 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016 Datadog, Inc.
+
+package rueidis
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"time"
+
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+
+	"github.com/redis/rueidis"
+)
+
+// connectResource is the resource name of the spans of the connections to the
+// server, see WithConnectSpans.
+const connectResource = "redis.connect"
+
+// StartConnectSpan starts a span for the connection to the server at addr, as a
+// child of the span found in ctx, if any, configured with the given options. It
+// allows tracing the connections established by the clients which are not
+// created with NewClient, such as the ones with a custom rueidis.ClientOption
+// DialFn, which must finish the returned span once connected, along with the
+// error of the connection, if any:
+//
+//	span, _ := rueidistrace.StartConnectSpan(ctx, dst)
+//	conn, err := dial(dst)
+//	span.Finish(tracer.WithError(err))
+func StartConnectSpan(ctx context.Context, addr string, opts ...ClientOption) (ddtrace.Span, context.Context) {
+	return startConnectSpan(ctx, newConfig(opts...), addr)
+}
+
+func startConnectSpan(ctx context.Context, cfg *clientConfig, addr string) (ddtrace.Span, context.Context) {
+	opts := append(targetTags(addr),
+		tracer.ServiceName(cfg.serviceName),
+		tracer.ResourceName(connectResource),
+	)
+	if cfg.transportTag {
+		transport := "tcp"
+		if _, ok := unixSocketPath(addr); ok {
+			transport = "unix"
+		}
+		opts = append(opts, tracer.Tag(TagTransport, transport))
+	}
+	ddh := &datadogHook{params: &params{config: cfg}}
+	return ddh.startSpan(ctx, opts...)
+}
+
+// withConnectSpans returns option with its DialFn wrapped to trace the
+// connections to the server, when WithConnectSpans is set in cfg.
+func withConnectSpans(option rueidis.ClientOption, cfg *clientConfig) rueidis.ClientOption {
+	if !cfg.connectSpans {
+		return option
+	}
+	dialFn := option.DialFn
+	option.DialFn = func(dst string, dialer *net.Dialer, tlsConfig *tls.Config) (net.Conn, error) {
+		span, _ := startConnectSpan(context.Background(), cfg, dst)
+		conn, err := dial(span, dialFn, dst, dialer, tlsConfig)
+		span.Finish(tracer.WithError(err))
+		return conn, err
+	}
+	return option
+}
+
+// dial connects to the server at dst with dialFn when it is set, and otherwise
+// as rueidis does, setting the duration of the TLS handshake on span. Without
+// dialFn, it does what tls.DialWithDialer does in the dial function of rueidis,
+// in two steps so as to time the handshake: it must track rueidis.dial.
+func dial(span ddtrace.Span, dialFn func(string, *net.Dialer, *tls.Config) (net.Conn, error), dst string, dialer *net.Dialer, tlsConfig *tls.Config) (net.Conn, error) {
+	if dialFn != nil {
+		return dialFn(dst, dialer, tlsConfig)
+	}
+	if tlsConfig == nil {
+		return dialer.Dial("tcp", dst)
+	}
+	// the dialer timeout and deadline apply to both the connection and the TLS
+	// handshake, as with tls.DialWithDialer
+	ctx := context.Background()
+	if dialer.Timeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, dialer.Timeout)
+		defer cancel()
+	}
+	if !dialer.Deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, dialer.Deadline)
+		defer cancel()
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", dst)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig.ServerName == "" {
+		host, _, err := net.SplitHostPort(dst)
+		if err != nil {
+			host = dst
+		}
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.ServerName = host
+	}
+	tlsConn := tls.Client(conn, tlsConfig)
+	start := time.Now()
+	err = tlsConn.HandshakeContext(ctx)
+	span.SetTag(TagTLSHandshake, time.Since(start).Microseconds())
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
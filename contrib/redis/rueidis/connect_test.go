@@ -0,0 +1,172 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016 Datadog, Inc.
+
+package rueidis
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/mocktracer"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+
+	"github.com/redis/rueidis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStartConnectSpan(t *testing.T) {
+	assert := assert.New(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	root, ctx := tracer.StartSpanFromContext(context.Background(), "root")
+	span, _ := StartConnectSpan(ctx, "10.0.0.1:6380", WithServiceName("my-redis"))
+	span.Finish(tracer.WithError(errors.New("connection refused")))
+	root.Finish()
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 2)
+	connect := spans[0]
+	assert.Equal("redis.command", connect.OperationName())
+	assert.Equal("redis.connect", connect.Tag(ext.ResourceName))
+	assert.Equal("my-redis", connect.Tag(ext.ServiceName))
+	assert.Equal("10.0.0.1", connect.Tag(ext.TargetHost))
+	assert.Equal("6380", connect.Tag(ext.TargetPort))
+	assert.EqualError(connect.Tag(ext.Error).(error), "connection refused")
+	assert.Equal(root.Context().SpanID(), connect.ParentID())
+}
+
+func TestConnectSpans(t *testing.T) {
+	assert := assert.New(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	option := withConnectSpans(rueidis.ClientOption{}, newConfig())
+	assert.Nil(option.DialFn)
+	option = withConnectSpans(rueidis.ClientOption{}, newConfig(WithConnectSpans()))
+	require.NotNil(t, option.DialFn)
+	conn, err := option.DialFn(ln.Addr().String(), &net.Dialer{}, nil)
+	require.NoError(t, err)
+	conn.Close()
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 1)
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	assert.Equal("redis.connect", spans[0].Tag(ext.ResourceName))
+	assert.Equal(host, spans[0].Tag(ext.TargetHost))
+	assert.Equal(port, spans[0].Tag(ext.TargetPort))
+	assert.NotContains(spans[0].Tags(), TagTLSHandshake)
+	assert.Nil(spans[0].Tag(ext.Error))
+}
+
+func TestConnectSpansTLS(t *testing.T) {
+	// the certificate of the test server is valid for 127.0.0.1
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	defer srv.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+	dialFn := withConnectSpans(rueidis.ClientOption{}, newConfig(WithConnectSpans())).DialFn
+	addr := srv.Listener.Addr().String()
+
+	t.Run("server-name", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		// the server name defaults to the host of the address, without which
+		// the certificate can't be verified, without modifying the given
+		// configuration
+		tlsConfig := &tls.Config{RootCAs: roots}
+		conn, err := dialFn(addr, &net.Dialer{}, tlsConfig)
+		require.NoError(t, err)
+		assert.NotEmpty(t, conn.(*tls.Conn).ConnectionState().VerifiedChains)
+		assert.Empty(t, tlsConfig.ServerName)
+		conn.Close()
+
+		// and the given one is used otherwise
+		_, err = dialFn(addr, &net.Dialer{}, &tls.Config{RootCAs: roots, ServerName: "redis.local"})
+		var hostnameErr x509.HostnameError
+		assert.ErrorAs(t, err, &hostnameErr)
+
+		spans := mt.FinishedSpans()
+		require.Len(t, spans, 2)
+		assert.Contains(t, spans[0].Tags(), TagTLSHandshake)
+		assert.Nil(t, spans[0].Tag(ext.Error))
+		assert.Contains(t, spans[1].Tags(), TagTLSHandshake)
+		assert.NotNil(t, spans[1].Tag(ext.Error))
+	})
+
+	// a server accepting connections without ever completing the handshake
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		var conns []net.Conn
+		defer func() {
+			for _, conn := range conns {
+				conn.Close()
+			}
+		}()
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conns = append(conns, conn)
+		}
+	}()
+
+	for name, dialer := range map[string]func() *net.Dialer{
+		"timeout":  func() *net.Dialer { return &net.Dialer{Timeout: 50 * time.Millisecond} },
+		"deadline": func() *net.Dialer { return &net.Dialer{Deadline: time.Now().Add(50 * time.Millisecond)} },
+	} {
+		t.Run(name, func(t *testing.T) {
+			mt := mocktracer.Start()
+			defer mt.Stop()
+
+			// the dialer bounds the handshake too
+			_, err := dialFn(ln.Addr().String(), dialer(), &tls.Config{RootCAs: roots})
+			assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+			spans := mt.FinishedSpans()
+			require.Len(t, spans, 1)
+			assert.Contains(t, spans[0].Tags(), TagTLSHandshake)
+			assert.NotNil(t, spans[0].Tag(ext.Error))
+		})
+	}
+}
+
+func TestNewClientAppliesOptionsOnce(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	calls := 0
+	countCalls := func(*clientConfig) { calls++ }
+	_, err := NewClient(rueidis.ClientOption{
+		InitAddress: []string{"127.0.0.1:6379"},
+		DialFn: func(string, *net.Dialer, *tls.Config) (net.Conn, error) {
+			return nil, errors.New("connection refused")
+		},
+	}, countCalls, WithConnectSpans(), WithClientInfoTrace())
+	require.Error(t, err)
+	assert.Equal(t, 1, calls)
+
+	// the dial hook uses the configuration of the client
+	spans := mt.FinishedSpans()
+	require.NotEmpty(t, spans)
+	assert.Equal(t, "redis.connect", spans[0].Tag(ext.ResourceName))
+}
@@ -0,0 +1,54 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016 Datadog, Inc.
+
+package rueidis
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+
+	"github.com/redis/rueidis"
+)
+
+// Error categories set as the "redis.error_category" tag by the default
+// classifier of WithErrorCategorizer.
+const (
+	// ErrorCategoryTimeout is the category of the commands which timed out.
+	ErrorCategoryTimeout = 1
+	// ErrorCategoryConnection is the category of the commands which failed
+	// because of the connection to the server.
+	ErrorCategoryConnection = 2
+	// ErrorCategoryServer is the category of the commands to which the server
+	// replied with an error.
+	ErrorCategoryServer = 3
+	// ErrorCategoryClient is the category of the other failed commands, such as
+	// the ones canceled by the caller.
+	ErrorCategoryClient = 4
+)
+
+// CategorizeError is the default classifier of WithErrorCategorizer, returning
+// the category of err among ErrorCategoryTimeout, ErrorCategoryConnection,
+// ErrorCategoryServer and ErrorCategoryClient.
+func CategorizeError(err error) int {
+	if _, ok := rueidis.IsRedisErr(err); ok {
+		return ErrorCategoryServer
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrorCategoryTimeout
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		if netErr.Timeout() {
+			return ErrorCategoryTimeout
+		}
+		return ErrorCategoryConnection
+	}
+	if errors.Is(err, rueidis.ErrClosing) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, net.ErrClosed) {
+		return ErrorCategoryConnection
+	}
+	return ErrorCategoryClient
+}
@@ -0,0 +1,67 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016 Datadog, Inc.
+
+package rueidis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"testing"
+
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/mocktracer"
+
+	"github.com/golang/mock/gomock"
+	"github.com/redis/rueidis"
+	"github.com/redis/rueidis/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCategorizeError(t *testing.T) {
+	for _, tc := range []struct {
+		err      error
+		category int
+	}{
+		{err: context.DeadlineExceeded, category: ErrorCategoryTimeout},
+		{err: fmt.Errorf("wrapped: %w", context.DeadlineExceeded), category: ErrorCategoryTimeout},
+		{err: &net.OpError{Op: "read", Err: os.ErrDeadlineExceeded}, category: ErrorCategoryTimeout},
+		{err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}, category: ErrorCategoryConnection},
+		{err: rueidis.ErrClosing, category: ErrorCategoryConnection},
+		{err: io.EOF, category: ErrorCategoryConnection},
+		{err: context.Canceled, category: ErrorCategoryClient},
+		{err: errors.New("oops"), category: ErrorCategoryClient},
+	} {
+		assert.Equal(t, tc.category, CategorizeError(tc.err), tc.err.Error())
+	}
+
+	err := mock.Result(mock.RedisError("WRONGTYPE Operation against a key holding the wrong kind of value")).Error()
+	assert.Equal(t, ErrorCategoryServer, CategorizeError(err))
+}
+
+func TestErrorCategorizer(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	mc := newMockClient(t)
+	mc.EXPECT().Do(gomock.Any(), mock.Match("GET", "key")).Return(mock.ErrorResult(context.DeadlineExceeded))
+	mc.EXPECT().Do(gomock.Any(), mock.Match("GET", "missing")).Return(mock.Result(mock.RedisNil()))
+	mc.EXPECT().Do(gomock.Any(), mock.Match("SET", "key", "value")).Return(mock.ErrorResult(errors.New("oops")))
+	ctx := context.Background()
+	client := WrapClient(mc, WithErrorCategorizer(nil))
+	client.Do(ctx, client.B().Get().Key("key").Build())
+	client.Do(ctx, client.B().Get().Key("missing").Build())
+	client = WrapClient(mc, WithErrorCategorizer(func(error) int { return 42 }))
+	client.Do(ctx, client.B().Set().Key("key").Value("value").Build())
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 3)
+	assert.Equal(t, ErrorCategoryTimeout, spans[0].Tag(TagErrorCategory))
+	assert.NotContains(t, spans[1].Tags(), TagErrorCategory)
+	assert.Equal(t, 42, spans[2].Tag(TagErrorCategory))
+}
@@ -0,0 +1,59 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016 Datadog, Inc.
+
+package rueidis_test
+
+import (
+	"context"
+
+	rueidistrace "gopkg.in/DataDog/dd-trace-go.v1/contrib/redis/rueidis"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+
+	"github.com/redis/rueidis"
+)
+
+// To start tracing Redis, simply create a new client using the library and continue
+// using as you normally would.
+func Example() {
+	ctx := context.Background()
+	// create a new Client
+	c, err := rueidistrace.NewClient(rueidis.ClientOption{InitAddress: []string{"127.0.0.1:6379"}})
+	if err != nil {
+		panic(err)
+	}
+	defer c.Close()
+
+	// any command emits a span
+	c.Do(ctx, c.B().Set().Key("test_key").Value("test_value").Build())
+
+	// optionally, create a new root span
+	root, ctx := tracer.StartSpanFromContext(context.Background(), "parent.request",
+		tracer.SpanType(ext.SpanTypeRedis),
+		tracer.ServiceName("web"),
+		tracer.ResourceName("/home"),
+	)
+
+	// commit further commands, which will inherit from the parent in the context.
+	c.Do(ctx, c.B().Set().Key("food").Value("cheese").Build())
+	root.Finish()
+}
+
+// You can also trace an already existing client by wrapping it.
+func ExampleWrapClient() {
+	ctx := context.Background()
+	c, err := rueidis.NewClient(rueidis.ClientOption{InitAddress: []string{"127.0.0.1:6379"}})
+	if err != nil {
+		panic(err)
+	}
+	c = rueidistrace.WrapClient(c, rueidistrace.WithServiceName("my-redis-service"))
+	defer c.Close()
+
+	// commands sent together with DoMulti are traced as a single pipeline span
+	c.DoMulti(ctx,
+		c.B().Incr().Key("pipeline_counter").Build(),
+		c.B().Expire().Key("pipeline_counter").Seconds(3600).Build(),
+	)
+}
@@ -0,0 +1,61 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016 Datadog, Inc.
+
+package rueidis
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/rueidis"
+)
+
+const (
+	// failoverBurst is the number of failover errors which must be seen within
+	// failoverWindow to consider that a failover is happening.
+	failoverBurst = 3
+	// failoverWindow is the time window within which failoverBurst failover
+	// errors must be seen to consider that a failover is happening.
+	failoverWindow = time.Second
+)
+
+// failoverDetector heuristically detects failovers from bursts of the errors
+// replied by the servers while the cluster topology changes, see
+// WithFailoverDetection. It is safe for concurrent use.
+type failoverDetector struct {
+	mu sync.Mutex
+	// errors holds the times of the last failoverBurst failover errors, in a
+	// ring starting at next.
+	errors [failoverBurst]time.Time
+	next   int
+}
+
+// observe records err when it is a failover error seen at t, and reports whether
+// a failover is happening at t.
+func (d *failoverDetector) observe(t time.Time, err error) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if isFailoverError(err) {
+		d.errors[d.next] = t
+		d.next = (d.next + 1) % failoverBurst
+	}
+	// the oldest of the last errors is the next one to be replaced
+	oldest := d.errors[d.next]
+	return !oldest.IsZero() && t.Sub(oldest) <= failoverWindow
+}
+
+// isFailoverError reports whether err is one of the MOVED, CLUSTERDOWN or
+// READONLY errors replied by the servers while a failover is happening.
+func isFailoverError(err error) bool {
+	e, ok := rueidis.IsRedisErr(err)
+	if !ok {
+		return false
+	}
+	if _, moved := e.IsMoved(); moved {
+		return true
+	}
+	return e.IsClusterDown() || strings.HasPrefix(e.Error(), "READONLY")
+}
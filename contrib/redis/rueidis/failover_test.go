@@ -0,0 +1,63 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016 Datadog, Inc.
+
+package rueidis
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/mocktracer"
+
+	"github.com/golang/mock/gomock"
+	"github.com/redis/rueidis/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFailoverDetector(t *testing.T) {
+	moved := mock.Result(mock.RedisError("MOVED 3999 127.0.0.1:6381")).Error()
+	readonly := mock.Result(mock.RedisError("READONLY You can't write against a read only replica.")).Error()
+	clusterDown := mock.Result(mock.RedisError("CLUSTERDOWN The cluster is down")).Error()
+	other := mock.Result(mock.RedisError("ERR oops")).Error()
+
+	var d failoverDetector
+	now := time.Now()
+	assert.False(t, d.observe(now, moved))
+	assert.False(t, d.observe(now.Add(100*time.Millisecond), other))
+	assert.False(t, d.observe(now.Add(200*time.Millisecond), readonly))
+	assert.True(t, d.observe(now.Add(300*time.Millisecond), clusterDown))
+	assert.True(t, d.observe(now.Add(900*time.Millisecond), nil))
+	assert.False(t, d.observe(now.Add(1500*time.Millisecond), nil))
+	assert.False(t, d.observe(now.Add(1600*time.Millisecond), errors.New("READONLY not a server reply")))
+}
+
+func TestFailoverDetection(t *testing.T) {
+	assert := assert.New(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	mc := newMockClient(t)
+	mc.EXPECT().Do(gomock.Any(), mock.Match("SET", "key", "value")).Return(mock.Result(mock.RedisError("READONLY You can't write against a read only replica."))).Times(3)
+	mc.EXPECT().Do(gomock.Any(), mock.Match("GET", "key")).Return(mock.Result(mock.RedisString("value"))).Times(2)
+	ctx := context.Background()
+	client := WrapClient(mc, WithFailoverDetection())
+	for i := 0; i < 3; i++ {
+		client.Do(ctx, client.B().Set().Key("key").Value("value").Build())
+	}
+	client.Do(ctx, client.B().Get().Key("key").Build())
+	client = WrapClient(mc)
+	client.Do(ctx, client.B().Get().Key("key").Build())
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 5)
+	assert.NotContains(spans[0].Tags(), TagFailover)
+	assert.NotContains(spans[1].Tags(), TagFailover)
+	assert.Equal(true, spans[2].Tag(TagFailover))
+	assert.Equal(true, spans[3].Tag(TagFailover))
+	assert.NotContains(spans[4].Tags(), TagFailover)
+}
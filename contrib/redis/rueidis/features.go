@@ -0,0 +1,461 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016 Datadog, Inc.
+
+package rueidis
+
+import (
+	"context"
+	"runtime"
+	"strconv"
+	"time"
+
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+
+	"github.com/redis/rueidis"
+)
+
+// tracedCommand is what the start hooks are given of the commands being traced.
+// The commands must not be retained, see datadogHook.start.
+type tracedCommand struct {
+	ctx    context.Context
+	client rueidis.Client
+	// resource is the resource name of the commands, before it is extended into
+	// the resource name of their span.
+	resource string
+	cmds     [][]string
+	span     *commandSpan
+}
+
+// commandResult is what the end hooks are given of the outcome of the commands
+// being traced.
+type commandResult struct {
+	err error
+	// failed is true when the span is finished with err.
+	failed     bool
+	finishTime time.Time
+	// begin is when the span started to be finished, by the wall clock, see
+	// WithHookOverheadTag.
+	begin time.Time
+}
+
+// duration returns how long the commands of span took.
+func (res commandResult) duration(span *commandSpan) time.Duration {
+	return res.finishTime.Sub(span.start)
+}
+
+// spanHooks holds the functions implementing the optional features of the spans
+// enabled on a client, which are selected once when the client is wrapped so
+// that the commands don't check the configuration for the disabled ones.
+type spanHooks struct {
+	// start is called before the span is started, and returns opts along with
+	// the options of the span of c.
+	start []func(c tracedCommand, opts []ddtrace.StartSpanOption) []ddtrace.StartSpanOption
+	// started is called once the span is started.
+	started []func(span *commandSpan)
+	// end is called before the span is finished.
+	end []func(span *commandSpan, res commandResult)
+	// done is called once the span is finished.
+	done []func(span *commandSpan, res commandResult)
+}
+
+// newSpanHooks returns the hooks of the features enabled on the client of ddh.
+// Their order is the order their tags are set in, which decides the tag kept
+// when two of them share a key.
+func newSpanHooks(ddh *datadogHook) spanHooks {
+	var h spanHooks
+	cfg := ddh.config
+	if cfg.commandsPerFlush {
+		h.start = append(h.start, ddh.startCommandsPerFlush)
+	}
+	if cfg.keyTag {
+		h.start = append(h.start, ddh.startKey)
+	}
+	if cfg.keyslotTag {
+		h.start = append(h.start, ddh.startKeyslot)
+	}
+	if cfg.idempotencyTag {
+		h.start = append(h.start, ddh.startIdempotent)
+	}
+	if cfg.keysCountTag {
+		h.start = append(h.start, ddh.startKeysCount)
+	}
+	if cfg.timeoutTag {
+		h.start = append(h.start, ddh.startTimeout)
+	}
+	if cfg.requestSizeTag {
+		h.start = append(h.start, ddh.startRequestSize)
+	}
+	if cfg.callerTag {
+		h.start = append(h.start, ddh.startCaller)
+	}
+	if cfg.schedulerTags {
+		h.start = append(h.start, ddh.startScheduler)
+	}
+	if ddh.serverStats != nil {
+		h.start = append(h.start, ddh.startServerStats)
+	}
+	if ddh.serverVersion != nil {
+		h.start = append(h.start, ddh.startServerVersion)
+	}
+	if ddh.respVersion != nil {
+		h.start = append(h.start, ddh.startRESPVersion)
+	}
+	if cfg.nodeZone != nil {
+		h.start = append(h.start, ddh.startNodeZone)
+	}
+	if cfg.baselineRTT != nil {
+		h.start = append(h.start, ddh.startBaselineRTT)
+		h.end = append(h.end, ddh.endServerProcessing)
+	}
+	if cfg.connectionIDTag {
+		h.start = append(h.start, ddh.startConnectionID)
+	}
+	if cfg.reconnectDetection {
+		h.start = append(h.start, ddh.startGeneration)
+	}
+	if cfg.queueDepthTag {
+		h.start = append(h.start, ddh.startQueueDepth)
+	}
+	if cfg.otelConventions {
+		if cfg.dbIndex >= 0 {
+			h.start = append(h.start, staticTag(ext.RedisDatabaseIndex, cfg.dbIndex))
+		}
+		h.end = append(h.end, ddh.endOTelStatus)
+	}
+	if cfg.clientName != "" {
+		h.start = append(h.start, staticTag(TagClientName, cfg.clientName))
+	}
+	if cfg.readPolicy != "" {
+		h.start = append(h.start, staticTag(TagReadPolicy, cfg.readPolicy))
+	}
+	if cfg.consistency != "" {
+		h.start = append(h.start, ddh.startConsistency)
+	}
+	if cfg.sourceLabel != "" {
+		h.start = append(h.start, staticTag(TagSource, cfg.sourceLabel))
+	}
+	if cfg.dataset != "" {
+		h.start = append(h.start, staticTag(TagDataset, cfg.dataset))
+	}
+	if len(cfg.measuredResources) > 0 {
+		h.start = append(h.start, ddh.startMeasured)
+	}
+	// the additional tags are set after the tags above and before the ones of
+	// WithPerCommandTags
+	h.start = append(h.start, ddh.startAdditionalTags)
+	if cfg.perCommandTags != nil {
+		h.start = append(h.start, ddh.startPerCommandTags)
+	}
+	if cfg.allocSampleRate > 0 {
+		h.started = append(h.started, ddh.startedAllocs)
+		h.end = append(h.end, endAllocs)
+	}
+	if cfg.slowThreshold > 0 {
+		h.end = append(h.end, ddh.endSlow)
+	}
+	if cfg.errorCategorizer != nil {
+		h.end = append(h.end, ddh.endErrorCategory)
+	}
+	if cfg.reconnectDetection {
+		h.end = append(h.end, endReconnected)
+	}
+	if ddh.failover != nil {
+		h.end = append(h.end, ddh.endFailover)
+	}
+	if cfg.hookOverheadTag {
+		// last, so that it accounts for the other hooks
+		h.end = append(h.end, endHookOverhead)
+	}
+	if ddh.commandRing != nil {
+		h.done = append(h.done, ddh.doneCommandRing)
+	}
+	if cfg.statsd != nil {
+		h.done = append(h.done, ddh.doneStatsd)
+	}
+	if cfg.prometheus != nil {
+		h.done = append(h.done, ddh.donePrometheus)
+	}
+	if cfg.finishHook != nil {
+		h.done = append(h.done, ddh.doneFinishHook)
+	}
+	if cfg.errorSink != nil {
+		h.done = append(h.done, ddh.doneErrorSink)
+	}
+	if cfg.samplingObserver != nil {
+		h.done = append(h.done, ddh.doneSamplingObserver)
+	}
+	return h
+}
+
+// staticTag returns a start hook setting the given tag on every span.
+func staticTag(key string, value interface{}) func(tracedCommand, []ddtrace.StartSpanOption) []ddtrace.StartSpanOption {
+	tag := tracer.Tag(key, value)
+	return func(_ tracedCommand, opts []ddtrace.StartSpanOption) []ddtrace.StartSpanOption {
+		return append(opts, tag)
+	}
+}
+
+func (ddh *datadogHook) startCommandsPerFlush(c tracedCommand, opts []ddtrace.StartSpanOption) []ddtrace.StartSpanOption {
+	if c.resource != pipelineResource {
+		return opts
+	}
+	return append(opts, tracer.Tag(TagCommandsPerFlush, len(c.cmds)))
+}
+
+func (ddh *datadogHook) startKey(c tracedCommand, opts []ddtrace.StartSpanOption) []ddtrace.StartSpanOption {
+	if len(c.cmds) != 1 {
+		return opts
+	}
+	if key, ok := firstKey(c.cmds[0]); ok {
+		opts = append(opts, tracer.Tag(TagKey, ddh.redactKey(key)))
+	}
+	return opts
+}
+
+func (ddh *datadogHook) startKeyslot(c tracedCommand, opts []ddtrace.StartSpanOption) []ddtrace.StartSpanOption {
+	if len(c.cmds) != 1 {
+		return opts
+	}
+	if key, ok := firstKey(c.cmds[0]); ok {
+		opts = append(opts, tracer.Tag(TagKeyslot, keyslot(key)))
+	}
+	return opts
+}
+
+func (ddh *datadogHook) startIdempotent(c tracedCommand, opts []ddtrace.StartSpanOption) []ddtrace.StartSpanOption {
+	if len(c.cmds) != 1 {
+		return opts
+	}
+	if idempotent, ok := ddh.idempotent(c.cmds[0]); ok {
+		opts = append(opts, tracer.Tag(TagIdempotent, idempotent))
+	}
+	return opts
+}
+
+func (ddh *datadogHook) startKeysCount(c tracedCommand, opts []ddtrace.StartSpanOption) []ddtrace.StartSpanOption {
+	if len(c.cmds) != 1 {
+		return opts
+	}
+	if n, ok := keysCount(c.cmds[0]); ok {
+		opts = append(opts, tracer.Tag(TagKeysCount, n))
+	}
+	return opts
+}
+
+func (ddh *datadogHook) startTimeout(c tracedCommand, opts []ddtrace.StartSpanOption) []ddtrace.StartSpanOption {
+	if deadline, ok := c.ctx.Deadline(); ok {
+		opts = append(opts, tracer.Tag(TagTimeout, deadline.Sub(c.span.start).Milliseconds()))
+	}
+	return opts
+}
+
+func (ddh *datadogHook) startRequestSize(c tracedCommand, opts []ddtrace.StartSpanOption) []ddtrace.StartSpanOption {
+	return append(opts, tracer.Tag(TagRequestBytes, requestSize(c.cmds...)))
+}
+
+func (ddh *datadogHook) startCaller(_ tracedCommand, opts []ddtrace.StartSpanOption) []ddtrace.StartSpanOption {
+	if caller := callerName(); caller != "" {
+		opts = append(opts, tracer.Tag(TagCaller, caller))
+	}
+	return opts
+}
+
+func (ddh *datadogHook) startScheduler(_ tracedCommand, opts []ddtrace.StartSpanOption) []ddtrace.StartSpanOption {
+	if id, ok := goroutineID(); ok {
+		opts = append(opts, tracer.Tag(TagGoroutineID, id))
+	}
+	return append(opts, tracer.Tag(TagGOMAXPROCS, runtime.GOMAXPROCS(0)))
+}
+
+func (ddh *datadogHook) startServerStats(c tracedCommand, opts []ddtrace.StartSpanOption) []ddtrace.StartSpanOption {
+	if st, ok := ddh.serverStats.get(c.resource); ok {
+		opts = append(opts,
+			tracer.Tag(TagServerCalls, st.calls),
+			tracer.Tag(TagServerUsecPerCall, st.usecPerCall),
+		)
+	}
+	return opts
+}
+
+func (ddh *datadogHook) startServerVersion(_ tracedCommand, opts []ddtrace.StartSpanOption) []ddtrace.StartSpanOption {
+	if v, ok := ddh.serverVersion.get(); ok {
+		opts = append(opts, tracer.Tag(TagServerVersion, v))
+	}
+	return opts
+}
+
+func (ddh *datadogHook) startRESPVersion(_ tracedCommand, opts []ddtrace.StartSpanOption) []ddtrace.StartSpanOption {
+	if v, ok := ddh.respVersion.get(); ok {
+		opts = append(opts, tracer.Tag(TagRESPVersion, v))
+	}
+	return opts
+}
+
+func (ddh *datadogHook) startNodeZone(c tracedCommand, opts []ddtrace.StartSpanOption) []ddtrace.StartSpanOption {
+	if node, ok := nodeAddr(c.client); ok {
+		if zone := ddh.config.nodeZone(node); zone != "" {
+			opts = append(opts, tracer.Tag(TagNodeZone, zone))
+		}
+	}
+	return opts
+}
+
+func (ddh *datadogHook) startBaselineRTT(c tracedCommand, opts []ddtrace.StartSpanOption) []ddtrace.StartSpanOption {
+	if node, ok := nodeAddr(c.client); ok {
+		c.span.baselineRTT, c.span.hasBaselineRTT = ddh.config.baselineRTT(node), true
+	}
+	return opts
+}
+
+func (ddh *datadogHook) startConnectionID(c tracedCommand, opts []ddtrace.StartSpanOption) []ddtrace.StartSpanOption {
+	if id, ok := connectionID(c.client); ok {
+		opts = append(opts, tracer.Tag(TagConnectionID, id))
+	}
+	return opts
+}
+
+func (ddh *datadogHook) startGeneration(c tracedCommand, opts []ddtrace.StartSpanOption) []ddtrace.StartSpanOption {
+	if r, ok := c.client.(connectionGenerationReporter); ok {
+		c.span.generations, c.span.generation = r, r.ConnectionGeneration()
+	}
+	return opts
+}
+
+func (ddh *datadogHook) startQueueDepth(c tracedCommand, opts []ddtrace.StartSpanOption) []ddtrace.StartSpanOption {
+	if depth, ok := queueDepth(c.client); ok {
+		opts = append(opts, tracer.Tag(TagQueueDepth, depth))
+	}
+	return opts
+}
+
+func (ddh *datadogHook) startConsistency(c tracedCommand, opts []ddtrace.StartSpanOption) []ddtrace.StartSpanOption {
+	return append(opts, tracer.Tag(TagConsistency, consistency(ddh.config.consistency, c.cmds...)))
+}
+
+func (ddh *datadogHook) startMeasured(c tracedCommand, opts []ddtrace.StartSpanOption) []ddtrace.StartSpanOption {
+	if ddh.config.measuredResources[c.span.resource] {
+		opts = append(opts, tracer.Measured())
+	}
+	return opts
+}
+
+func (ddh *datadogHook) startAdditionalTags(_ tracedCommand, opts []ddtrace.StartSpanOption) []ddtrace.StartSpanOption {
+	return append(opts, ddh.additionalTags...)
+}
+
+func (ddh *datadogHook) startPerCommandTags(c tracedCommand, opts []ddtrace.StartSpanOption) []ddtrace.StartSpanOption {
+	return append(opts, ddh.config.perCommandTags(c.resource)...)
+}
+
+func (ddh *datadogHook) startedAllocs(span *commandSpan) {
+	if sampledByRate(span.Context().SpanID(), ddh.config.allocSampleRate) {
+		span.allocs, span.trackAllocs = heapAllocs(), true
+	}
+}
+
+func endAllocs(span *commandSpan, _ commandResult) {
+	if !span.trackAllocs {
+		return
+	}
+	if n, ok := allocDelta(span.allocs, heapAllocs()); ok {
+		span.SetTag(TagAllocBytes, n)
+	}
+}
+
+func (ddh *datadogHook) endSlow(span *commandSpan, res commandResult) {
+	if res.duration(span) > ddh.config.slowThreshold {
+		span.SetTag(ext.ResourceName, span.resource+" (slow)")
+	}
+}
+
+func (ddh *datadogHook) endOTelStatus(span *commandSpan, res commandResult) {
+	if res.failed {
+		span.SetTag(TagOTelStatusCode, "ERROR")
+		span.SetTag(TagOTelStatusDescription, res.err.Error())
+	}
+}
+
+func (ddh *datadogHook) endErrorCategory(span *commandSpan, res commandResult) {
+	if res.failed {
+		span.SetTag(TagErrorCategory, ddh.config.errorCategorizer(res.err))
+	}
+}
+
+func endReconnected(span *commandSpan, _ commandResult) {
+	if span.generations != nil {
+		span.SetTag(TagReconnected, span.generations.ConnectionGeneration() != span.generation)
+	}
+}
+
+func (ddh *datadogHook) endFailover(span *commandSpan, res commandResult) {
+	if ddh.failover.observe(res.finishTime, res.err) {
+		span.SetTag(TagFailover, true)
+	}
+}
+
+func (ddh *datadogHook) endServerProcessing(span *commandSpan, res commandResult) {
+	if !span.hasBaselineRTT {
+		return
+	}
+	processing := res.duration(span) - span.baselineRTT
+	if processing < 0 {
+		processing = 0
+	}
+	span.SetTag(TagServerProcessing, float64(processing)/float64(time.Millisecond))
+}
+
+func endHookOverhead(span *commandSpan, res commandResult) {
+	span.SetTag(TagHookOverhead, (span.overhead + time.Since(res.begin)).Microseconds())
+}
+
+func (ddh *datadogHook) doneCommandRing(span *commandSpan, res commandResult) {
+	rec := CommandRecord{Verb: span.verb, Duration: res.duration(span)}
+	if res.failed {
+		rec.Err = res.err
+	}
+	ddh.commandRing.add(rec)
+}
+
+func (ddh *datadogHook) doneStatsd(span *commandSpan, res commandResult) {
+	tags := []string{"verb:" + span.verb, "error:" + strconv.FormatBool(res.failed)}
+	ddh.config.statsd.Timing(durationMetric, res.duration(span), tags, 1)
+}
+
+func (ddh *datadogHook) donePrometheus(span *commandSpan, res commandResult) {
+	r := ddh.config.prometheus
+	labels := map[string]string{prometheusVerbLabel: span.verb}
+	r.IncCounter(commandsMetric, labels)
+	if res.failed {
+		r.IncCounter(commandErrorsMetric, labels)
+	}
+	r.ObserveHistogram(commandDurationMetric, res.duration(span).Seconds(), labels)
+}
+
+func (ddh *datadogHook) doneFinishHook(span *commandSpan, res commandResult) {
+	var err error
+	if res.failed {
+		err = res.err
+	}
+	ddh.config.finishHook(span.verb, res.duration(span), err)
+}
+
+func (ddh *datadogHook) doneErrorSink(span *commandSpan, res commandResult) {
+	if res.failed {
+		ddh.config.errorSink(span.verb, res.err)
+	}
+}
+
+func (ddh *datadogHook) doneSamplingObserver(span *commandSpan, _ commandResult) {
+	if span.follower {
+		// the decision is carried by the span of the group, whose first command
+		// reports it
+		return
+	}
+	if kept, ok := ddh.samplingDecision(span); ok {
+		ddh.config.samplingObserver(span.verb, kept)
+	}
+}
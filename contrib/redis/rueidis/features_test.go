@@ -0,0 +1,37 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016 Datadog, Inc.
+
+package rueidis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewSpanHooks(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		h := newSpanHooks(&datadogHook{params: &params{config: newConfig()}})
+		// only the additional tags are set on the spans by default
+		assert.Len(t, h.start, 1)
+		assert.Empty(t, h.started)
+		assert.Empty(t, h.end)
+		assert.Empty(t, h.done)
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		cfg := newConfig(
+			WithKeyTag(),
+			WithSlowResourceSuffix(time.Second),
+			WithErrorSink(func(string, error) {}),
+		)
+		h := newSpanHooks(&datadogHook{params: &params{config: cfg}})
+		assert.Len(t, h.start, 2)
+		assert.Empty(t, h.started)
+		assert.Len(t, h.end, 1)
+		assert.Len(t, h.done, 1)
+	})
+}
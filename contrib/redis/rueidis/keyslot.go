@@ -0,0 +1,41 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016 Datadog, Inc.
+
+package rueidis
+
+import "strings"
+
+// keyslotCount is the number of hash slots of a Redis Cluster.
+const keyslotCount = 16384
+
+// keyslot returns the hash slot of key in a Redis Cluster, which is the CRC16 of
+// the key modulo 16384. When the key contains a non-empty hash tag, such as
+// "{user1000}.following", only the hash tag is hashed, as per the cluster
+// specification.
+func keyslot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16(key) % keyslotCount)
+}
+
+// crc16 returns the CRC16 checksum of s, using the CCITT polynomial 0x1021 as
+// done by Redis Cluster (the XMODEM variant).
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
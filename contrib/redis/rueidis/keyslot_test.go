@@ -0,0 +1,55 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016 Datadog, Inc.
+
+package rueidis
+
+import (
+	"context"
+	"testing"
+
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/mocktracer"
+
+	"github.com/golang/mock/gomock"
+	"github.com/redis/rueidis/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyslot(t *testing.T) {
+	assert.Equal(t, uint16(0x31c3), crc16("123456789"))
+	for key, slot := range map[string]int{
+		"foo":                  12182,
+		"somekey":              11058,
+		"user1000":             3443,
+		"{user1000}.following": 3443,
+		"{user1000}.followers": 3443,
+		"foo{bar}{zap}":        5061, // only the first hash tag is used
+		"foo{}{bar}":           8363, // the whole key is hashed when the hash tag is empty
+		"foo{{bar}}":           4015, // the hash tag is "{bar"
+		"{user1000":            8723, // unterminated hash tag
+	} {
+		assert.Equal(t, slot, keyslot(key), key)
+	}
+}
+
+func TestKeyslotTag(t *testing.T) {
+	assert := assert.New(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	mc := newMockClient(t)
+	mc.EXPECT().Do(gomock.Any(), gomock.Any()).Return(mock.Result(mock.RedisString("OK"))).Times(3)
+	client := WrapClient(mc, WithKeyslotTag())
+	ctx := context.Background()
+	client.Do(ctx, client.B().Get().Key("foo").Build())
+	client.Do(ctx, client.B().Get().Key("{user1000}.following").Build())
+	client.Do(ctx, client.B().Ping().Build())
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 3)
+	assert.Equal(12182, spans[0].Tag(TagKeyslot))
+	assert.Equal(3443, spans[1].Tag(TagKeyslot))
+	assert.NotContains(spans[2].Tags(), TagKeyslot)
+}
@@ -0,0 +1,1049 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016 Datadog, Inc.
+
+package rueidis
+
+import (
+	"context"
+	"math"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace"
+	"gopkg.in/DataDog/dd-trace-go.v1/internal"
+	"gopkg.in/DataDog/dd-trace-go.v1/internal/log"
+	"gopkg.in/DataDog/dd-trace-go.v1/internal/namingschema"
+
+	"github.com/redis/rueidis"
+)
+
+const defaultServiceName = "redis.client"
+
+type clientConfig struct {
+	serviceName   string
+	spanName      string
+	analyticsRate float64
+	skipRaw       bool
+	callerTag     bool
+	// serverStatsInterval is the interval at which the server command stats are
+	// polled. Zero disables polling.
+	serverStatsInterval time.Duration
+	shardService        func(node string) string
+	requestSizeTag      bool
+	ignoredCommands     map[string]bool
+	hookStats           *HookStats
+	emptyReplyAsMiss    bool
+	samplingObserver    func(verb string, kept bool)
+	serverVersionTag    bool
+	perCommandTags      func(cmd string) []ddtrace.StartSpanOption
+	resourceKey         func(key string) string
+	retryTag            bool
+	keyTag              bool
+	keyRedactor         *regexp.Regexp
+	keyReplacement      string
+	tracer              ddtrace.Tracer
+	blockingCommands    map[string]bool
+	messageSpans        bool
+	onlyWithinTrace     bool
+	slowThreshold       time.Duration
+	clientName          string
+	nodeZone            func(addr string) string
+	maxRawCommands      int
+	readPolicy          string
+	connectionIDTag     bool
+	functionResource    bool
+	trackingTag         bool
+	statsd              StatsdClient
+	sourceLabel         string
+	excludedResources   map[string]bool
+	debugRingSize       int
+	timeoutTag          bool
+	keyPrefixRates      map[string]float64
+	respVersionTag      bool
+	txAbortedTag        bool
+	clientInfoTrace     bool
+	dbIndex             int
+	resultTypeTag       bool
+	measuredResources   map[string]bool
+	nilAsError          map[string]bool
+	keysCountTag        bool
+	finishHook          func(verb string, dur time.Duration, err error)
+	rawSampleRate       float64
+	hookOverheadTag     bool
+	keyslotTag          bool
+	commandsPerFlush    bool
+	errorCategorizer    func(error) int
+	connectSpans        bool
+	serverLoadingTag    bool
+	spanCtxExtractor    func(ctx context.Context) ddtrace.SpanContext
+	idempotencyTag      bool
+	idempotency         map[string]bool
+	aggregationWindow   time.Duration
+	aggregationMax      int
+	baselineRTT         func(addr string) time.Duration
+	failoverDetection   bool
+	allocSampleRate     float64
+	replyTagExtractor   func(verb string, reply rueidis.RedisResult) []ddtrace.StartSpanOption
+	consistency         string
+	otelConventions     bool
+	queueDepthTag       bool
+	reconnectDetection  bool
+	errorSink           func(verb string, err error)
+	schedulerTags       bool
+	cacheSizeTag        bool
+	prometheus          PrometheusRegistry
+	transportTag        bool
+	cachedSuffix        bool
+	dataset             string
+	// now returns the current time, used to time the command spans. It is
+	// time.Now, and only replaced by the tests to control the durations.
+	now func() time.Time
+}
+
+// ClientOption represents an option that can be used to create or wrap a client.
+type ClientOption func(*clientConfig)
+
+func defaults(cfg *clientConfig) {
+	// unless set with WithServiceName, the service name is the one of the
+	// tracer, then DD_SERVICE when the tracer isn't started yet, and
+	// defaultServiceName when there is none
+	fallback := defaultServiceName
+	if svc := os.Getenv("DD_SERVICE"); svc != "" {
+		fallback = svc
+	}
+	cfg.serviceName = namingschema.NewDefaultServiceName(fallback).GetName()
+	cfg.spanName = namingschema.NewRedisOutboundOp().GetName()
+	if internal.BoolEnv("DD_TRACE_REDIS_ANALYTICS_ENABLED", false) {
+		cfg.analyticsRate = 1.0
+	} else {
+		cfg.analyticsRate = math.NaN()
+	}
+	cfg.blockingCommands = defaultBlockingCommands
+	cfg.dbIndex = -1
+	cfg.rawSampleRate = 1
+	cfg.now = time.Now
+}
+
+// validate resets the invalid values of cfg, which result from applying options
+// with out of range arguments, to their defaults, logging a warning for each of
+// them.
+func validate(cfg *clientConfig) {
+	if cfg.maxRawCommands < 0 {
+		log.Warn("contrib/redis/rueidis: ignoring negative maximum number of raw commands %d, inlining all commands", cfg.maxRawCommands)
+		cfg.maxRawCommands = 0
+	}
+	if cfg.serverStatsInterval < 0 {
+		log.Warn("contrib/redis/rueidis: ignoring negative server stats interval %s, not polling the server stats", cfg.serverStatsInterval)
+		cfg.serverStatsInterval = 0
+	}
+	if cfg.slowThreshold < 0 {
+		log.Warn("contrib/redis/rueidis: ignoring negative slow command threshold %s", cfg.slowThreshold)
+		cfg.slowThreshold = 0
+	}
+	if cfg.rawSampleRate < 0 || cfg.rawSampleRate > 1 || math.IsNaN(cfg.rawSampleRate) {
+		log.Warn("contrib/redis/rueidis: ignoring raw command sample rate %v out of [0, 1], recording all raw commands", cfg.rawSampleRate)
+		cfg.rawSampleRate = 1
+	}
+	if cfg.aggregationWindow < 0 || (cfg.aggregationWindow > 0 && cfg.aggregationMax < 2) {
+		log.Warn("contrib/redis/rueidis: ignoring aggregation of up to %d commands within %s, not aggregating commands", cfg.aggregationMax, cfg.aggregationWindow)
+		cfg.aggregationWindow = 0
+		cfg.aggregationMax = 0
+	}
+	if cfg.allocSampleRate < 0 || cfg.allocSampleRate > 1 || math.IsNaN(cfg.allocSampleRate) {
+		log.Warn("contrib/redis/rueidis: ignoring allocation tracking sample rate %v out of [0, 1], not tracking allocations", cfg.allocSampleRate)
+		cfg.allocSampleRate = 0
+	}
+	if cfg.debugRingSize < 0 {
+		log.Warn("contrib/redis/rueidis: ignoring negative debug ring buffer size %d, not recording the commands", cfg.debugRingSize)
+		cfg.debugRingSize = 0
+	}
+	if cfg.keyRedactor != nil && !cfg.keyTag && cfg.resourceKey == nil {
+		log.Warn("contrib/redis/rueidis: the key redactor has no effect unless WithKeyTag or WithResourceFromFirstKey is used")
+	}
+}
+
+// DefaultConfig holds the default configuration of the clients traced by this
+// package, which holds when no ClientOption is given.
+type DefaultConfig struct {
+	// ServiceName is the service name of the spans.
+	ServiceName string
+	// SpanName is the operation name of the spans.
+	SpanName string
+	// AnalyticsRate is the sampling rate for Trace Analytics events, which is
+	// NaN when Trace Analytics is disabled.
+	AnalyticsRate float64
+	// SkipRawCommand reports whether the "redis.raw_command" tag is skipped.
+	SkipRawCommand bool
+}
+
+// Defaults returns the default configuration of the clients traced by this
+// package. As it depends on the environment and on the configuration of the
+// tracer, it should be called once the tracer is started.
+func Defaults() DefaultConfig {
+	cfg := new(clientConfig)
+	defaults(cfg)
+	return DefaultConfig{
+		ServiceName:    cfg.serviceName,
+		SpanName:       cfg.spanName,
+		AnalyticsRate:  cfg.analyticsRate,
+		SkipRawCommand: cfg.skipRaw,
+	}
+}
+
+// defaultBlockingCommands holds the verbs of the commands which block by default.
+var defaultBlockingCommands = map[string]bool{
+	"BLMOVE":     true,
+	"BLMPOP":     true,
+	"BLPOP":      true,
+	"BRPOP":      true,
+	"BRPOPLPUSH": true,
+	"BZMPOP":     true,
+	"BZPOPMAX":   true,
+	"BZPOPMIN":   true,
+	"WAIT":       true,
+	"WAITAOF":    true,
+	"XREAD":      true,
+	"XREADGROUP": true,
+}
+
+// WithSkipRawCommand reports whether to skip setting the "redis.raw_command" tag
+// on instrumenation spans. This may be useful if the Datadog Agent is not
+// set up to obfuscate this value and it could contain sensitive information.
+func WithSkipRawCommand(skip bool) ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.skipRaw = skip
+	}
+}
+
+// WithServiceName sets the given service name for the client. It defaults to the
+// service name of the tracer, set with DD_SERVICE or tracer.WithService, and to
+// "redis.client" when there is none.
+func WithServiceName(name string) ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.serviceName = name
+	}
+}
+
+// WithAnalytics enables Trace Analytics for all started spans.
+func WithAnalytics(on bool) ClientOption {
+	return func(cfg *clientConfig) {
+		if on {
+			cfg.analyticsRate = 1.0
+		} else {
+			cfg.analyticsRate = math.NaN()
+		}
+	}
+}
+
+// WithAnalyticsRate sets the sampling rate for Trace Analytics events
+// correlated to started spans.
+func WithAnalyticsRate(rate float64) ClientOption {
+	return func(cfg *clientConfig) {
+		if rate >= 0.0 && rate <= 1.0 {
+			cfg.analyticsRate = rate
+		} else {
+			cfg.analyticsRate = math.NaN()
+		}
+	}
+}
+
+// WithCallerTag enables the "redis.caller" tag on instrumentation spans, holding
+// the name of the function which issued the command. Finding it requires walking
+// the call stack on every command, so it is disabled by default.
+func WithCallerTag() ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.callerTag = true
+	}
+}
+
+// WithServerStats enables polling the server's INFO commandstats section every
+// interval, in the background. The last reported statistics of the traced command
+// are then set on its span as the "redis.server.calls" and
+// "redis.server.usec_per_call" tags. The polling stops when the client is closed.
+func WithServerStats(interval time.Duration) ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.serverStatsInterval = interval
+	}
+}
+
+// WithShardService sets a function returning the service name of the spans of
+// commands sent to the given node address, which allows each shard of a cluster to
+// show up as its own service. The node is only known when the command is sent
+// through a client bound to a single node, such as the ones returned by Nodes().
+// The service name set with WithServiceName is used when the node is unknown or
+// when fn returns an empty string.
+func WithShardService(fn func(node string) string) ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.shardService = fn
+	}
+}
+
+// WithRequestSizeTag enables the "redis.request_bytes" tag on instrumentation
+// spans, holding the total size in bytes of the command and its arguments. For
+// commands sent together with DoMulti or DoMultiCache, it is the total size of the
+// batch. It is disabled by default as it requires going through every argument.
+func WithRequestSizeTag() ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.requestSizeTag = true
+	}
+}
+
+// WithIgnoredCommands disables tracing of the commands with the given verbs, such
+// as "PING". Verbs are matched regardless of their case. Commands sent together
+// with DoMulti or DoMultiCache are only ignored when all of them are.
+func WithIgnoredCommands(verbs ...string) ClientOption {
+	return func(cfg *clientConfig) {
+		if cfg.ignoredCommands == nil {
+			cfg.ignoredCommands = make(map[string]bool, len(verbs))
+		}
+		for _, verb := range verbs {
+			cfg.ignoredCommands[strings.ToUpper(verb)] = true
+		}
+	}
+}
+
+// WithHookStats sets stats to be updated with the number of spans created,
+// skipped and finished with an error by the client, which can be used to confirm
+// that the instrumentation is working.
+func WithHookStats(stats *HookStats) ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.hookStats = stats
+	}
+}
+
+// WithEmptyReplyAsMiss reports nil replies and empty array replies to commands
+// sent with DoCache or DoMultiCache as cache misses in the "redis.cache_hit" tag,
+// even when they were served from the client side cache. This is useful when such
+// replies mean that the requested data was not found.
+func WithEmptyReplyAsMiss() ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.emptyReplyAsMiss = true
+	}
+}
+
+// WithSamplingObserver sets a function called with the uppercased verb of every
+// traced command, or "redis.pipeline" for the commands sent together with DoMulti
+// or DoMultiCache, and whether its trace is kept by the sampler. It is called once
+// the span is finished, and only when the sampling decision is known. The commands
+// aggregated into the span of another one with WithAggregation have no span of
+// their own, so only the first command of the group reports the decision.
+func WithSamplingObserver(fn func(verb string, kept bool)) ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.samplingObserver = fn
+	}
+}
+
+// WithServerVersionTag enables the "redis.server_version" tag on instrumentation
+// spans, holding the version of the server. It is fetched once from the server's
+// INFO server section, in the background, so the spans of the commands sent
+// before it is known, or when it can't be fetched, don't have the tag.
+func WithServerVersionTag() ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.serverVersionTag = true
+	}
+}
+
+// WithPerCommandTags sets a function returning additional options of the span of
+// every command, given its resource name, such as "GET" or "redis.pipeline". They
+// are applied after the tags set by this package. As fn is called for every
+// command, it is on the hot path of the client and should be kept cheap.
+func WithPerCommandTags(fn func(cmd string) []ddtrace.StartSpanOption) ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.perCommandTags = fn
+	}
+}
+
+// WithResourceFromFirstKey sets a function normalizing the first key of every
+// command, such as turning "user:123" into "user:*", so that the resource name of
+// its span is made of the verb followed by the normalized key, e.g. "GET user:*".
+// The function must collapse the variable parts of the keys, since every distinct
+// normalized key makes for a distinct resource. The resource name is left
+// unchanged for the commands without a key, for the commands sent together with
+// DoMulti or DoMultiCache and when fn returns an empty string.
+func WithResourceFromFirstKey(fn func(key string) string) ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.resourceKey = fn
+	}
+}
+
+// WithRetryTag enables the "redis.retries" tag on instrumentation spans, holding
+// the number of replies to the traced commands which are errors the client
+// retries on, such as MOVED and ASK redirects. The retries performed internally
+// by the cluster client aren't visible from the hook, so only the replies which
+// are handed back to the hook are counted.
+func WithRetryTag() ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.retryTag = true
+	}
+}
+
+// WithKeyTag enables the "redis.key" tag on instrumentation spans, holding the
+// first key of the command, after redaction when WithKeyRedactor is used. It is
+// disabled by default since keys may contain sensitive identifiers.
+func WithKeyTag() ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.keyTag = true
+	}
+}
+
+// WithKeyRedactor replaces the parts of the keys matching re with replacement,
+// which may refer to the submatches of re as in regexp.Regexp.ReplaceAllString,
+// before they are tagged with WithKeyTag or normalized with
+// WithResourceFromFirstKey. This allows keeping the structure of the keys while
+// masking their sensitive parts. The keys found in the "redis.raw_command" tag are
+// not redacted: use WithSkipRawCommand to leave it out.
+func WithKeyRedactor(re *regexp.Regexp, replacement string) ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.keyRedactor = re
+		cfg.keyReplacement = replacement
+	}
+}
+
+// WithTracer sets the tracer starting the spans of the client, instead of the
+// global tracer. This is useful to export the spans of the client to a tracer
+// other than the global one. The spans are still started as children of the span
+// found in the context of the commands.
+func WithTracer(t ddtrace.Tracer) ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.tracer = t
+	}
+}
+
+// WithBlockingCommands replaces the verbs of the commands whose spans get the
+// "redis.blocking" tag, which allows them to be excluded from latency monitors as
+// they may legitimately take a long time. It defaults to the blocking commands of
+// Redis, such as BLPOP or WAIT. XREAD and XREADGROUP are only considered blocking
+// when given the BLOCK argument.
+func WithBlockingCommands(verbs ...string) ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.blockingCommands = make(map[string]bool, len(verbs))
+		for _, verb := range verbs {
+			cfg.blockingCommands[strings.ToUpper(verb)] = true
+		}
+	}
+}
+
+// WithMessageSpans enables the creation of a span for every message received with
+// Receive, as a child of the span of the subscribing command, covering the call
+// to the message callback. It is tagged with the channel of the message, and with
+// the event type of keyspace notifications as "redis.keyspace_event".
+func WithMessageSpans() ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.messageSpans = true
+	}
+}
+
+// WithOnlyWithinTrace disables tracing of the commands sent without an active span
+// in their context, which would otherwise start new traces. This keeps background
+// work, such as maintenance tasks, from creating orphan root spans.
+func WithOnlyWithinTrace() ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.onlyWithinTrace = true
+	}
+}
+
+// WithSlowResourceSuffix appends the " (slow)" suffix to the resource name of the
+// spans of the commands taking longer than threshold, such as "GET (slow)", so
+// that slow commands are aggregated separately.
+func WithSlowResourceSuffix(threshold time.Duration) ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.slowThreshold = threshold
+	}
+}
+
+// WithClientName sets the "redis.client_name" tag of every span to name, which
+// allows correlating the spans with the client connections listed by the server.
+// NewClient uses the ClientName of its rueidis.ClientOption by default, which
+// rueidis sets on every connection. The CLIENT SETNAME commands sent through the
+// client are not taken into account, since they only name one of its connections.
+func WithClientName(name string) ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.clientName = name
+	}
+}
+
+// WithNodeZoneMapper sets a function returning the availability zone of the node
+// with the given address, which is set as the "redis.node_az" tag of the spans of
+// the commands sent to it. As with WithShardService, the node is only known when
+// the command is sent through a client bound to a single node, such as the ones
+// returned by Nodes(). The tag is omitted when the node is unknown or when fn
+// returns an empty string.
+func WithNodeZoneMapper(fn func(addr string) string) ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.nodeZone = fn
+	}
+}
+
+// WithMaxRawCommands limits to n the number of commands of a pipeline inlined
+// into the "redis.raw_command" tag, the ones left out being counted in a final
+// "+N more" line. This bounds the size of the tag for large DoMulti calls.
+// A value of 0 or less, the default, inlines all commands.
+func WithMaxRawCommands(n int) ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.maxRawCommands = n
+	}
+}
+
+// WithReadPolicyTag sets the "redis.read_policy" tag of every span to policy,
+// such as "master", "replica" or "nearest", which describes the nodes the client
+// reads from and helps interpreting the latency of the commands. NewClient uses
+// "replica" by default when the ReplicaOnly flag of its rueidis.ClientOption is
+// set. The SendToReplicas function being opaque, no policy is detected from it.
+func WithReadPolicyTag(policy string) ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.readPolicy = policy
+	}
+}
+
+// WithConnectionIDTag sets the "redis.connection_id" tag of the spans to the
+// identifier of the connection serving the command, which helps debugging the
+// issues specific to a connection. The tag is omitted when the identifier is not
+// available, which is currently always the case with the clients of rueidis, as
+// they do not expose their connections to the hooks.
+func WithConnectionIDTag() ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.connectionIDTag = true
+	}
+}
+
+// WithFunctionResource appends the name of the called function to the resource
+// name of the FCALL and FCALL_RO commands, such as "FCALL myfunc", so that the
+// calls of different functions are grouped separately. The name is also set as
+// the "redis.function" tag.
+func WithFunctionResource() ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.functionResource = true
+	}
+}
+
+// WithTrackingTag sets the "redis.tracking" tag of the spans, reporting whether
+// the commands were sent with DoCache or DoMultiCache and thus opted into the
+// client side caching backed by the RESP3 client tracking, rather than directly.
+// Unlike the "redis.cache_hit" tag, it does not depend on the reply.
+func WithTrackingTag() ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.trackingTag = true
+	}
+}
+
+// StatsdClient implementations can time the commands traced by the client.
+type StatsdClient interface {
+	// Timing creates a histogram metric of the values registered as the duration of a certain event.
+	Timing(event string, duration time.Duration, tags []string, rate float64) error
+}
+
+// WithStatsd specifies a statsd client recording the duration of every traced
+// command in the "rueidis.command.duration" histogram, tagged with the verb of
+// the command, or "redis.pipeline" for pipelines, and whether it failed. This
+// provides latency distributions regardless of the sampling of the traces. By
+// default, no metrics are sent.
+func WithStatsd(client StatsdClient) ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.statsd = client
+	}
+}
+
+// WithSourceLabel sets the "redis.source" tag of every span to label, naming the
+// subsystem issuing the commands through the client, such as "session" or
+// "rate_limiter". Wrapping a client once per subsystem labels its commands
+// without passing the label along with every call.
+func WithSourceLabel(label string) ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.sourceLabel = label
+	}
+}
+
+// WithExcludeResources disables tracing of the commands whose span would have
+// one of the given resource names, such as "PING", or "GET health" for the GET
+// commands of the health key when used with WithResourceFromFirstKey. Unlike
+// WithIgnoredCommands, it allows ignoring only some of the commands with a verb,
+// such as the ones of a health probe. Resource names are matched exactly.
+func WithExcludeResources(resources []string) ClientOption {
+	return func(cfg *clientConfig) {
+		if cfg.excludedResources == nil {
+			cfg.excludedResources = make(map[string]bool, len(resources))
+		}
+		for _, r := range resources {
+			cfg.excludedResources[r] = true
+		}
+	}
+}
+
+// WithDebugRingBuffer makes the client record the verb, duration and error of
+// its last n traced commands in memory. They can be inspected with the
+// DebugCommands method of the client, which implements DebugRingBuffer, when
+// debugging without looking up the traces. Nothing is recorded when n is 0.
+func WithDebugRingBuffer(n int) ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.debugRingSize = n
+	}
+}
+
+// WithTimeoutTag sets the "redis.timeout_ms" tag of the spans of the commands
+// sent with a context having a deadline, holding the time left in milliseconds
+// before the deadline when the command was sent. This tells apart commands timing
+// out after 50ms from ones timing out after 5s. The tag is omitted when the
+// context has no deadline.
+func WithTimeoutTag() ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.timeoutTag = true
+	}
+}
+
+// WithKeyPrefixSampling sets the Trace Analytics sampling rate of the spans of
+// the commands whose first key starts with one of the prefixes of rates, such as
+// 1 for "admin:" and 0.1 for "cache:", the longest matching prefix winning. The
+// rate set with WithAnalyticsRate applies to the other commands, and to the
+// commands sent together with DoMulti or DoMultiCache. Rates outside of [0, 1]
+// are ignored.
+func WithKeyPrefixSampling(rates map[string]float64) ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.keyPrefixRates = make(map[string]float64, len(rates))
+		for prefix, rate := range rates {
+			if rate >= 0.0 && rate <= 1.0 {
+				cfg.keyPrefixRates[prefix] = rate
+			}
+		}
+	}
+}
+
+// WithRESPVersionTag enables the "redis.resp_version" tag on instrumentation
+// spans, holding the version of the RESP protocol negotiated with the server,
+// "2" or "3", on which the availability of the client side caching depends.
+// rueidis doesn't expose it, so it is fetched once in the background with a
+// HELLO command, and the spans of the commands sent before it is known, or when
+// it can't be fetched, don't have the tag.
+func WithRESPVersionTag() ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.respVersionTag = true
+	}
+}
+
+// WithTransactionAbortedTag sets the "redis.transaction_aborted" tag of the spans
+// of EXEC commands, or of the commands sent together with one, reporting whether
+// the transaction was aborted because one of its WATCHed keys was modified, in
+// which case EXEC replies nil. This allows measuring the contention of optimistic
+// locking.
+func WithTransactionAbortedTag() ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.txAbortedTag = true
+	}
+}
+
+// WithClientInfoTrace makes NewClient name the library of the connections of the
+// client after the service name of the spans, such as
+// "rueidis(dd-trace-go_my-service)", with CLIENT SETINFO when they are
+// established, so that CLIENT LIST on the server shows which service owns every
+// connection. It has no effect when the ClientSetInfo of the rueidis.ClientOption
+// is already set, or when wrapping an existing client with WrapClient.
+func WithClientInfoTrace() ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.clientInfoTrace = true
+	}
+}
+
+// WithDatabaseIndex sets the index of the database selected by the client, which
+// the "db.redis.database_index" tag of every span is set to when
+// WithOTelConventions is given, allowing to group the spans by database.
+// NewClient uses the SelectDB of its rueidis.ClientOption by default. The SELECT
+// commands sent through the client are not taken into account, since they only
+// change the database of one of its connections. A negative index, the default
+// of WrapClient, omits the tag.
+func WithDatabaseIndex(index int) ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.dbIndex = index
+	}
+}
+
+// WithResultTypeTag sets the "redis.result_type" tag of the spans of the commands
+// sent with Do or DoCache to the RESP type of their reply, such as "string",
+// "integer", "array", "null" or "error", which helps debugging the parsing of the
+// replies. It is not set on the spans of the commands sent together with DoMulti
+// or DoMultiCache.
+func WithResultTypeTag() ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.resultTypeTag = true
+	}
+}
+
+// WithMeasuredResources marks the spans with one of the given resource names,
+// such as "GET", as measured, so that trace metrics are computed for them only.
+// This allows controlling the cardinality and cost of the metrics. As with
+// WithExcludeResources, resource names are matched exactly.
+func WithMeasuredResources(resources []string) ClientOption {
+	return func(cfg *clientConfig) {
+		if cfg.measuredResources == nil {
+			cfg.measuredResources = make(map[string]bool, len(resources))
+		}
+		for _, r := range resources {
+			cfg.measuredResources[r] = true
+		}
+	}
+}
+
+// WithNilAsErrorForVerbs records an error on the spans of the commands with one of
+// the given verbs, such as "GET", when they return a Nil reply. By default, Nil
+// replies are not considered errors, since they usually mean that a key doesn't
+// exist. Verbs are case insensitive.
+func WithNilAsErrorForVerbs(verbs []string) ClientOption {
+	return func(cfg *clientConfig) {
+		if cfg.nilAsError == nil {
+			cfg.nilAsError = make(map[string]bool, len(verbs))
+		}
+		for _, verb := range verbs {
+			cfg.nilAsError[strings.ToUpper(verb)] = true
+		}
+	}
+}
+
+// WithKeysCountTag sets the "redis.keys_count" tag of the spans of the commands
+// taking several keys, such as MGET, DEL or MSET, to their number of keys, which
+// helps spotting expensive operations. The tag is only set for the commands
+// whose arguments are known to the integration.
+func WithKeysCountTag() ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.keysCountTag = true
+	}
+}
+
+// WithFinishHook sets a function called once the span of every traced command is
+// finished, with the uppercased verb of the command, or "redis.pipeline" for the
+// commands sent together with DoMulti or DoMultiCache, its duration and the error
+// recorded on the span, if any. The commands which are not traced, such as the
+// ones set with WithIgnoredCommands, don't invoke it, since they aren't timed.
+func WithFinishHook(fn func(verb string, dur time.Duration, err error)) ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.finishHook = fn
+	}
+}
+
+// WithRawCommandSampleRate sets the fraction of the spans, between 0 and 1, on
+// which the "redis.raw_command" tag is set, to balance its cost and the
+// visibility it gives. Spans are sampled deterministically by span ID. It
+// defaults to 1, which records the raw command on every span, while 0 is
+// equivalent to WithSkipRawCommand(true).
+func WithRawCommandSampleRate(rate float64) ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.rawSampleRate = rate
+	}
+}
+
+// WithHookOverheadTag sets the "redis.hook_overhead_us" tag of the spans to the
+// time spent by the instrumentation, in microseconds, starting and finishing
+// them, excluding the time spent running the command. This helps quantifying the
+// cost of tracing. The time spent after the tag is set, in finishing the span
+// itself, isn't included.
+func WithHookOverheadTag() ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.hookOverheadTag = true
+	}
+}
+
+// WithKeyslotTag sets the "redis.keyslot" tag of the spans of the commands to the
+// Redis Cluster hash slot of their first key, computed as the CRC16 of the key,
+// or of its hash tag, modulo 16384. This helps correlating the commands with the
+// output of CLUSTER SLOTS. It is not set for commands sent together.
+func WithKeyslotTag() ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.keyslotTag = true
+	}
+}
+
+// WithCommandsPerFlushTag sets the "redis.commands_per_flush" tag of the spans of
+// the commands sent together with DoMulti or DoMultiCache to the number of
+// commands written to the server per round trip, which measures the efficiency
+// of the batching over time. As the commands sent together are flushed in a
+// single round trip, it is the number of commands of the span.
+func WithCommandsPerFlushTag() ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.commandsPerFlush = true
+	}
+}
+
+// WithErrorCategorizer sets the "redis.error_category" tag of the spans finished
+// with an error to the numeric category returned by fn for the error, which
+// makes alerting on classes of errors easier. When fn is nil, CategorizeError is
+// used, categorizing errors as timeout, connection, server or client errors.
+func WithErrorCategorizer(fn func(error) int) ClientOption {
+	return func(cfg *clientConfig) {
+		if fn == nil {
+			fn = CategorizeError
+		}
+		cfg.errorCategorizer = fn
+	}
+}
+
+// WithConnectSpans makes NewClient trace the connections it establishes to the
+// servers with "redis.connect" spans, tagged with the address of the server and
+// the duration of the TLS handshake, if any. The handshake isn't timed when a
+// custom DialFn is set in the rueidis.ClientOption. It has no effect on the
+// clients given to WrapClient, whose connections can be traced with
+// StartConnectSpan instead.
+func WithConnectSpans() ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.connectSpans = true
+	}
+}
+
+// WithServerLoadingTag sets the "redis.server_loading" tag of the spans of the
+// commands rejected with a LOADING error by a server loading its dataset in
+// memory, such as a replica starting up. These transient rejections are then not
+// recorded as errors on the spans, to distinguish them from real failures.
+func WithServerLoadingTag() ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.serverLoadingTag = true
+	}
+}
+
+// WithSpanContextExtractor sets a function returning the span context the spans
+// of the commands sent with ctx are started as children of, rather than the span
+// found in ctx. This allows parenting the commands sent by a worker pool to the
+// request they are sent for, when its span context is propagated out of band.
+// When fn returns nil, the span found in ctx is used as the parent, if any.
+func WithSpanContextExtractor(fn func(ctx context.Context) ddtrace.SpanContext) ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.spanCtxExtractor = fn
+	}
+}
+
+// WithIdempotencyTag sets the "redis.idempotent" tag of the spans of the commands
+// to whether they are idempotent, such as GET or SET, or not, such as INCR or
+// LPUSH, which tells whether a retried command could have been applied twice.
+// The tag is only set for the commands known to the integration, and overrides
+// maps verbs to whether they are idempotent, adding to or replacing the built-in
+// ones. It is not set for commands sent together.
+func WithIdempotencyTag(overrides map[string]bool) ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.idempotencyTag = true
+		if cfg.idempotency == nil {
+			cfg.idempotency = make(map[string]bool, len(overrides))
+		}
+		for verb, idempotent := range overrides {
+			cfg.idempotency[strings.ToUpper(verb)] = idempotent
+		}
+	}
+}
+
+// WithAggregation represents the commands with the same verb and parent span sent
+// within window of each other by a single span, the one of the first of them, to
+// reduce the volume of spans of chatty workloads. The "redis.aggregated_count"
+// tag of the span is set to the number of commands it represents, up to
+// maxCount, and the span is finished once window elapsed or maxCount commands
+// were sent, whichever comes first, and all of them completed. The other
+// commands don't get a span of their own, but they are still reported to the
+// statsd client, the Prometheus registry and the hooks and sinks of the other
+// options, and their errors are counted in the "redis.aggregated_errors" tag of
+// the span, which gets the first of them when its own command didn't fail.
+// Commands without a parent span, or sent together with DoMulti or
+// DoMultiCache, are not aggregated.
+func WithAggregation(window time.Duration, maxCount int) ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.aggregationWindow = window
+		cfg.aggregationMax = maxCount
+	}
+}
+
+// WithBaselineRTT sets a function returning the expected network round trip time
+// to the node at the given address, such as "10.0.0.1:6379", which is subtracted
+// from the duration of the commands to set the "redis.server_processing_ms" tag,
+// floored at 0. This separates the network time from the server time in
+// cross-region setups. As with WithNodeZoneMapper, the tag is only set when the
+// node serving the command is known, which is the case for the clients bound to
+// a single node.
+func WithBaselineRTT(fn func(addr string) time.Duration) ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.baselineRTT = fn
+	}
+}
+
+// WithFailoverDetection sets the "redis.failover" tag of the spans of the
+// commands completed while a failover seems to be happening, which helps
+// correlating latency spikes with failovers. A failover is considered to be
+// happening when at least 3 MOVED, CLUSTERDOWN or READONLY errors were replied
+// by the servers within the last second.
+func WithFailoverDetection() ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.failoverDetection = true
+	}
+}
+
+// WithAllocTracking sets the "redis.alloc_bytes" tag of a fraction of the spans,
+// between 0 and 1, to the number of bytes allocated on the heap while their
+// command was running. Spans are sampled deterministically by span ID. As the
+// allocations are counted for the whole process, they include the ones of the
+// goroutines running concurrently with the command. Reading them has a cost, so
+// the rate should be kept low in production.
+func WithAllocTracking(rate float64) ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.allocSampleRate = rate
+	}
+}
+
+// WithReplyTagExtractor sets a function returning the tags to set on the span of
+// the commands sent with Do or DoCache, such as tracer.Tag, extracted from their
+// reply, such as a version field of a JSON value. It is called with the
+// uppercased verb of the command once the reply is received, even when the reply
+// is an error, which it must check for before converting the reply. It is not
+// called for the commands sent together with DoMulti or DoMultiCache.
+func WithReplyTagExtractor(fn func(verb string, reply rueidis.RedisResult) []ddtrace.StartSpanOption) ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.replyTagExtractor = fn
+	}
+}
+
+// WithConsistencyTag sets the "redis.consistency" tag of the spans to the given
+// consistency mode expected by the commands of the client, such as "eventual",
+// which documents the consistency expectations in the traces. The tag is set to
+// "strong" instead on the spans of the WAIT and WAITAOF commands, including when
+// sent together with other commands, as done to read one's writes.
+func WithConsistencyTag(mode string) ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.consistency = mode
+	}
+}
+
+// WithOTelConventions sets the OpenTelemetry tags on the spans, in addition to
+// the Datadog ones, for the backends relying on the OpenTelemetry conventions.
+// The spans finished with an error get the "otel.status_code" tag set to "ERROR"
+// and the "otel.status_description" tag set to the error message, and the
+// "db.redis.database_index" tag is set to the index of the database given with
+// WithDatabaseIndex, if any.
+func WithOTelConventions() ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.otelConventions = true
+	}
+}
+
+// WithQueueDepthTag sets the "redis.queue_depth" tag of the spans to the number
+// of outstanding requests of the client when the command is sent, high values
+// indicating that the client is saturated. The tag is omitted when the depth is
+// not available, which is currently always the case with the clients of rueidis,
+// as the depth of their queues is internal to them and not exposed to the hooks.
+func WithQueueDepthTag() ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.queueDepthTag = true
+	}
+}
+
+// WithReconnectDetection sets the "redis.reconnected" tag of the spans to whether
+// the client reconnected to the server while the command was running, which
+// helps attributing latency spikes to connection churn. Reconnections are
+// detected from the generation of the connection of the client, incremented on
+// every reconnection. The tag is omitted when the generation is not available,
+// which is currently always the case with the clients of rueidis, as they do not
+// expose their connections to the hooks.
+func WithReconnectDetection() ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.reconnectDetection = true
+	}
+}
+
+// WithErrorSink sets a function called with the uppercased verb of the command,
+// or "redis.pipeline" for the commands sent together with DoMulti or
+// DoMultiCache, and the error recorded on its span, once it is finished. This
+// allows reporting the errors to an error tracking system. The errors which are
+// not recorded on the spans don't invoke it, such as Nil replies, unless their
+// verb is set with WithNilAsErrorForVerbs, or LOADING errors with
+// WithServerLoadingTag.
+func WithErrorSink(fn func(verb string, err error)) ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.errorSink = fn
+	}
+}
+
+// WithSchedulerTags sets the "redis.goroutine_id" and "redis.gomaxprocs" tags of
+// the spans to the identifier of the goroutine which issued the command and to
+// the GOMAXPROCS setting at that time, which helps correlating the latency of
+// the commands with the contention of the Go scheduler. The processor (P) the
+// goroutine runs on is not exposed by the runtime, so the goroutine id is
+// reported instead. It is read from the stack trace of the goroutine, which is
+// expensive, so this option should only be enabled while investigating.
+func WithSchedulerTags() ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.schedulerTags = true
+	}
+}
+
+// WithCacheSizeTag sets the "redis.cache_entry_bytes" tag of the spans of the
+// commands sent with DoCache to the approximate size in bytes of their reply,
+// which is held in the client side cache, whether it was served from it or just
+// populated into it. This helps sizing the memory of the cache. The size of the
+// commands sent with DoMultiCache is the sum of the sizes of their replies. It
+// is estimated from the strings and numbers of the replies, without accounting
+// for the overhead of the cache itself, and the tag is omitted when all the
+// replies are errors.
+func WithCacheSizeTag() ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.cacheSizeTag = true
+	}
+}
+
+// PrometheusRegistry implementations can record the Prometheus metrics of the
+// commands traced by the client, such as with the counter and histogram vectors
+// of a prometheus.Registry. This package doesn't depend on the Prometheus client
+// library, which is left to the implementations.
+type PrometheusRegistry interface {
+	// IncCounter increments the counter with the given name and label values.
+	IncCounter(name string, labels map[string]string)
+	// ObserveHistogram adds value to the histogram with the given name and label
+	// values.
+	ObserveHistogram(name string, value float64, labels map[string]string)
+}
+
+// WithPrometheusRegistry specifies a registry recording the metrics of every
+// traced command, with a "verb" label set to the verb of the command, or
+// "redis.pipeline" for pipelines: the "rueidis_commands_total" and
+// "rueidis_command_errors_total" counters of the commands and of those which
+// failed, and the "rueidis_command_duration_seconds" histogram of their
+// durations. The commands are measured the same way as for their spans and
+// WithStatsd. By default, no metrics are recorded.
+func WithPrometheusRegistry(registry PrometheusRegistry) ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.prometheus = registry
+	}
+}
+
+// WithTransportTag sets the "redis.transport" tag of the spans to "unix" when the
+// client is connected to the server through a unix socket, whose address is an
+// absolute path such as "/var/run/redis.sock" or a "unix://" URL, and to "tcp"
+// otherwise. The transport matters when interpreting the latency of the
+// commands, unix sockets avoiding the network stack. As rueidis always dials
+// TCP, the tag is only derived from the addresses: connecting to a unix socket
+// requires a rueidis.ClientOption DialFn dialing it, such as:
+//
+//	DialFn: func(dst string, dialer *net.Dialer, _ *tls.Config) (net.Conn, error) {
+//		return dialer.Dial("unix", strings.TrimPrefix(dst, "unix://"))
+//	},
+func WithTransportTag() ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.transportTag = true
+	}
+}
+
+// WithCachedResourceSuffix appends " (cached)" to the resource name of the spans
+// of the commands sent with DoCache or DoMultiCache, such as "GET (cached)", so
+// that they are aggregated separately from the same commands sent directly,
+// whether their reply was served from the client side cache or not. The suffix
+// is appended to the resource name the span would otherwise have, and the
+// resources given to WithExcludeResources and WithMeasuredResources don't
+// include it.
+func WithCachedResourceSuffix() ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.cachedSuffix = true
+	}
+}
+
+// WithDataset sets the "redis.dataset" tag of every span to name, the logical
+// dataset held by the server the client is connected to, such as "sessions" or
+// "carts", for the services partitioning their data across several instances.
+// This allows breaking down the commands per dataset without setting a
+// different service name per instance.
+func WithDataset(name string) ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.dataset = name
+	}
+}
@@ -0,0 +1,71 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016 Datadog, Inc.
+
+package rueidis
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"gopkg.in/DataDog/dd-trace-go.v1/internal/globalconfig"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaults(t *testing.T) {
+	t.Run("baseline", func(t *testing.T) {
+		cfg := Defaults()
+		assert.Equal(t, "redis.client", cfg.ServiceName)
+		assert.Equal(t, "redis.command", cfg.SpanName)
+		assert.True(t, math.IsNaN(cfg.AnalyticsRate))
+		assert.False(t, cfg.SkipRawCommand)
+	})
+
+	t.Run("env", func(t *testing.T) {
+		t.Setenv("DD_SERVICE", "my-service")
+		t.Setenv("DD_TRACE_REDIS_ANALYTICS_ENABLED", "true")
+		cfg := Defaults()
+		assert.Equal(t, "my-service", cfg.ServiceName)
+		assert.Equal(t, 1.0, cfg.AnalyticsRate)
+	})
+
+	t.Run("global-service", func(t *testing.T) {
+		t.Setenv("DD_SERVICE", "env-service")
+		prev := globalconfig.ServiceName()
+		globalconfig.SetServiceName("my-service")
+		defer globalconfig.SetServiceName(prev)
+
+		// the service name of the tracer has precedence over DD_SERVICE
+		assert.Equal(t, "my-service", Defaults().ServiceName)
+	})
+}
+
+func TestValidate(t *testing.T) {
+	cfg := new(clientConfig)
+	defaults(cfg)
+	for _, fn := range []ClientOption{
+		WithMaxRawCommands(-1),
+		WithServerStats(-time.Second),
+		WithSlowResourceSuffix(-time.Second),
+		WithRawCommandSampleRate(2),
+		WithAggregation(time.Second, 1),
+		WithAllocTracking(-1),
+		WithDebugRingBuffer(-1),
+	} {
+		fn(cfg)
+	}
+	validate(cfg)
+
+	def := new(clientConfig)
+	defaults(def)
+	assert.Equal(t, def.maxRawCommands, cfg.maxRawCommands)
+	assert.Equal(t, def.serverStatsInterval, cfg.serverStatsInterval)
+	assert.Equal(t, def.slowThreshold, cfg.slowThreshold)
+	assert.Equal(t, def.rawSampleRate, cfg.rawSampleRate)
+	assert.Equal(t, def.aggregationWindow, cfg.aggregationWindow)
+	assert.Equal(t, def.allocSampleRate, cfg.allocSampleRate)
+	assert.Equal(t, def.debugRingSize, cfg.debugRingSize)
+}
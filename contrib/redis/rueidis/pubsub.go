@@ -0,0 +1,81 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016 Datadog, Inc.
+
+package rueidis
+
+import (
+	"context"
+	"strings"
+
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+
+	"github.com/redis/rueidis"
+)
+
+// messageResource is the resource name of the spans of the messages received
+// with Receive, see WithMessageSpans.
+const messageResource = "redis.message"
+
+// subscribeCommands holds the verbs of the commands subscribing to, or
+// unsubscribing from, the channels or patterns given as arguments.
+var subscribeCommands = map[string]bool{
+	"SUBSCRIBE":    true,
+	"UNSUBSCRIBE":  true,
+	"PSUBSCRIBE":   true,
+	"PUNSUBSCRIBE": true,
+	"SSUBSCRIBE":   true,
+	"SUNSUBSCRIBE": true,
+}
+
+// channelCount returns the number of channels or patterns cmd subscribes to or
+// unsubscribes from, when it is a subscribe command.
+func channelCount(cmd []string) (int, bool) {
+	if !subscribeCommands[commandVerb(cmd)] {
+		return 0, false
+	}
+	return len(cmd) - 1, true
+}
+
+// startMessageSpan starts the span of msg, received through client, as a child
+// of the span found in ctx.
+func (ddh *datadogHook) startMessageSpan(ctx context.Context, client rueidis.Client, msg rueidis.PubSubMessage) ddtrace.Span {
+	startOpts := make([]ddtrace.StartSpanOption, 0, 4+len(ddh.additionalTags))
+	startOpts = append(startOpts,
+		tracer.ServiceName(ddh.serviceName(client)),
+		tracer.ResourceName(messageResource),
+		tracer.Tag(TagChannel, msg.Channel),
+	)
+	if msg.Pattern != "" {
+		startOpts = append(startOpts, tracer.Tag(TagPattern, msg.Pattern))
+	}
+	if event, ok := keyspaceEvent(msg); ok {
+		startOpts = append(startOpts, tracer.Tag(TagKeyspaceEvent, event))
+	}
+	startOpts = append(startOpts, ddh.additionalTags...)
+	span, _ := ddh.startSpan(ctx, startOpts...)
+	ddh.config.hookStats.spanCreated()
+	return span
+}
+
+// keyspaceEvent returns the event type of msg when it is a keyspace
+// notification. Keyspace notifications are published on the
+// "__keyspace@<db>__:<key>" channels with the event as message, and keyevent
+// notifications on the "__keyevent@<db>__:<event>" channels with the key as
+// message.
+func keyspaceEvent(msg rueidis.PubSubMessage) (string, bool) {
+	prefix, suffix, ok := strings.Cut(msg.Channel, "__:")
+	if !ok {
+		return "", false
+	}
+	switch {
+	case strings.HasPrefix(prefix, "__keyspace@"):
+		return msg.Message, msg.Message != ""
+	case strings.HasPrefix(prefix, "__keyevent@"):
+		return suffix, suffix != ""
+	default:
+		return "", false
+	}
+}
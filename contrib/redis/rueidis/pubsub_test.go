@@ -0,0 +1,92 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016 Datadog, Inc.
+
+package rueidis
+
+import (
+	"context"
+	"testing"
+
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/mocktracer"
+
+	"github.com/golang/mock/gomock"
+	"github.com/redis/rueidis"
+	"github.com/redis/rueidis/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessageSpans(t *testing.T) {
+	ctx := context.Background()
+	assert := assert.New(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	mc := newMockClient(t)
+	mc.EXPECT().Receive(gomock.Any(), mock.Match("PSUBSCRIBE", "__key*__:*"), gomock.Any()).DoAndReturn(
+		func(_ context.Context, _ rueidis.Completed, fn func(msg rueidis.PubSubMessage)) error {
+			fn(rueidis.PubSubMessage{Pattern: "__key*__:*", Channel: "__keyspace@0__:user:123", Message: "expired"})
+			fn(rueidis.PubSubMessage{Pattern: "__key*__:*", Channel: "__keyevent@0__:del", Message: "user:123"})
+			return nil
+		})
+	client := WrapClient(mc, WithMessageSpans())
+	err := client.Receive(ctx, client.B().Psubscribe().Pattern("__key*__:*").Build(), func(msg rueidis.PubSubMessage) {})
+	assert.NoError(err)
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 3)
+	subscribe := spans[2]
+	assert.Equal("PSUBSCRIBE", subscribe.Tag(ext.ResourceName))
+	for _, span := range spans[:2] {
+		assert.Equal(subscribe.SpanID(), span.ParentID())
+		assert.Equal("redis.message", span.Tag(ext.ResourceName))
+		assert.Equal("__key*__:*", span.Tag(TagPattern))
+		assert.Equal(ext.SpanTypeRedis, span.Tag(ext.SpanType))
+	}
+	assert.Equal("__keyspace@0__:user:123", spans[0].Tag(TagChannel))
+	assert.Equal("expired", spans[0].Tag(TagKeyspaceEvent))
+	assert.Equal("__keyevent@0__:del", spans[1].Tag(TagChannel))
+	assert.Equal("del", spans[1].Tag(TagKeyspaceEvent))
+}
+
+func TestKeyspaceEvent(t *testing.T) {
+	for _, tt := range []struct {
+		msg   rueidis.PubSubMessage
+		event string
+		ok    bool
+	}{
+		{msg: rueidis.PubSubMessage{Channel: "__keyspace@0__:mykey", Message: "set"}, event: "set", ok: true},
+		{msg: rueidis.PubSubMessage{Channel: "__keyevent@1__:expired", Message: "mykey"}, event: "expired", ok: true},
+		{msg: rueidis.PubSubMessage{Channel: "news", Message: "hello"}},
+		{msg: rueidis.PubSubMessage{Channel: "__keyspace@0__:mykey"}},
+	} {
+		event, ok := keyspaceEvent(tt.msg)
+		assert.Equal(t, tt.event, event)
+		assert.Equal(t, tt.ok, ok)
+	}
+}
+
+func TestSubscribeChannels(t *testing.T) {
+	ctx := context.Background()
+	assert := assert.New(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	mc := newMockClient(t)
+	mc.EXPECT().Do(gomock.Any(), gomock.Any()).Return(mock.Result(mock.RedisString("OK"))).Times(3)
+	client := WrapClient(mc)
+	client.Do(ctx, client.B().Subscribe().Channel("a", "b", "c").Build())
+	client.Do(ctx, client.B().Punsubscribe().Pattern("news.*").Build())
+	client.Do(ctx, client.B().Get().Key("key").Build())
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 3)
+	assert.Equal("SUBSCRIBE", spans[0].Tag(ext.ResourceName))
+	assert.Equal(3, spans[0].Tag(TagChannels))
+	assert.Equal("PUNSUBSCRIBE", spans[1].Tag(ext.ResourceName))
+	assert.Equal(1, spans[1].Tag(TagChannels))
+	assert.NotContains(spans[2].Tags(), TagChannels)
+}
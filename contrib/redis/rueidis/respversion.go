@@ -0,0 +1,77 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016 Datadog, Inc.
+
+package rueidis
+
+import (
+	"context"
+	"strconv"
+	"sync/atomic"
+
+	"gopkg.in/DataDog/dd-trace-go.v1/internal/log"
+
+	"github.com/redis/rueidis"
+)
+
+// respVersion holds the version of the RESP protocol negotiated by a client with
+// the server, once known.
+type respVersion struct {
+	version atomic.Value // string
+}
+
+// fetchRESPVersion fetches the negotiated version of the RESP protocol in the
+// background, from the reply to a HELLO command sent without a protocol version,
+// which doesn't switch the protocol of the connection. The given client must not
+// be traced, to avoid tracing the HELLO command.
+func fetchRESPVersion(client rueidis.Client) *respVersion {
+	r := new(respVersion)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), serverVersionTimeout)
+		defer cancel()
+		msg, err := client.Do(ctx, client.B().Hello().Build()).ToMessage()
+		if err != nil {
+			log.Debug("contrib/redis/rueidis: failed to fetch the RESP version: %v", err)
+			return
+		}
+		if v, ok := parseRESPVersion(msg); ok {
+			r.version.Store(v)
+		}
+	}()
+	return r
+}
+
+// get returns the version of the RESP protocol, if it is known.
+func (r *respVersion) get() (string, bool) {
+	v, ok := r.version.Load().(string)
+	return v, ok
+}
+
+// parseRESPVersion returns the protocol version found in the "proto" field of the
+// reply to a HELLO command, which is a map in RESP3 and a flat array of field
+// and value pairs in RESP2.
+func parseRESPVersion(msg rueidis.RedisMessage) (string, bool) {
+	switch {
+	case msg.IsMap():
+	case msg.IsArray():
+		if values, _ := msg.ToArray(); len(values)%2 != 0 {
+			return "", false
+		}
+	default:
+		return "", false
+	}
+	fields, err := msg.AsMap()
+	if err != nil {
+		return "", false
+	}
+	proto, ok := fields["proto"]
+	if !ok {
+		return "", false
+	}
+	v, err := proto.AsInt64()
+	if err != nil {
+		return "", false
+	}
+	return strconv.FormatInt(v, 10), true
+}
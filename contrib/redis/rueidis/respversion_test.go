@@ -0,0 +1,85 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016 Datadog, Inc.
+
+package rueidis
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/mocktracer"
+
+	"github.com/golang/mock/gomock"
+	"github.com/redis/rueidis"
+	"github.com/redis/rueidis/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRESPVersion(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		msg      rueidis.RedisMessage
+		expected string
+	}{
+		{
+			name: "resp3",
+			msg: mock.RedisMap(map[string]rueidis.RedisMessage{
+				"server": mock.RedisString("redis"),
+				"proto":  mock.RedisInt64(3),
+			}),
+			expected: "3",
+		},
+		{
+			name: "resp2",
+			msg: mock.RedisArray(
+				mock.RedisString("server"), mock.RedisString("redis"),
+				mock.RedisString("proto"), mock.RedisInt64(2),
+			),
+			expected: "2",
+		},
+		{
+			name: "odd-array",
+			msg:  mock.RedisArray(mock.RedisString("proto")),
+		},
+		{
+			name: "string",
+			msg:  mock.RedisString("OK"),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			v, ok := parseRESPVersion(tc.msg)
+			assert.Equal(t, tc.expected, v)
+			assert.Equal(t, tc.expected != "", ok)
+		})
+	}
+}
+
+func TestRESPVersionTag(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	mc := newMockClient(t)
+	mc.EXPECT().Do(gomock.Any(), mock.Match("HELLO")).Return(mock.Result(mock.RedisMap(map[string]rueidis.RedisMessage{
+		"server": mock.RedisString("redis"),
+		"proto":  mock.RedisInt64(3),
+	})))
+	mc.EXPECT().Do(gomock.Any(), mock.Match("GET", "key")).Return(mock.Result(mock.RedisString("value"))).AnyTimes()
+	client := WrapClient(mc, WithRESPVersionTag())
+
+	// the version is fetched in the background
+	require.Eventually(t, func() bool {
+		mt.Reset()
+		client.Do(context.Background(), client.B().Get().Key("key").Build())
+		spans := mt.FinishedSpans()
+		return len(spans) == 1 && spans[0].Tag(TagRESPVersion) != nil
+	}, time.Second, 10*time.Millisecond)
+
+	// the HELLO command is not traced
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "3", spans[0].Tag(TagRESPVersion))
+}
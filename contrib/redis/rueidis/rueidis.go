@@ -0,0 +1,1397 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016 Datadog, Inc.
+
+// Package rueidis provides functions to trace the redis/rueidis package (https://github.com/redis/rueidis).
+//
+// The global tags configured with DD_TAGS or tracer.WithGlobalTag are set on
+// every span by the tracer, including the spans of this package.
+package rueidis
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"reflect"
+	"runtime"
+	"runtime/metrics"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+	"gopkg.in/DataDog/dd-trace-go.v1/internal/log"
+	"gopkg.in/DataDog/dd-trace-go.v1/internal/telemetry"
+
+	"github.com/redis/rueidis"
+	"github.com/redis/rueidis/rueidishook"
+)
+
+const componentName = "redis/rueidis"
+
+// pipelineResource is the resource name of the spans of the commands sent
+// together with DoMulti or DoMultiCache.
+const pipelineResource = "redis.pipeline"
+
+// durationMetric is the name of the histogram of the durations of the commands
+// sent to the statsd client set with WithStatsd.
+const durationMetric = "rueidis.command.duration"
+
+// The names of the metrics recorded by the registry set with
+// WithPrometheusRegistry.
+const (
+	commandsMetric        = "rueidis_commands_total"
+	commandErrorsMetric   = "rueidis_command_errors_total"
+	commandDurationMetric = "rueidis_command_duration_seconds"
+	prometheusVerbLabel   = "verb"
+)
+
+func init() {
+	telemetry.LoadIntegration(componentName)
+}
+
+type datadogHook struct {
+	*params
+}
+
+// params holds the tracer and a set of parameters which are recorded with every trace.
+type params struct {
+	config         *clientConfig
+	additionalTags []ddtrace.StartSpanOption
+	serverStats    *serverStats
+	serverVersion  *serverVersion
+	respVersion    *respVersion
+	aggregator     *aggregator
+	failover       *failoverDetector
+	commandRing    *commandRing
+	// hooks implement the optional features of the spans enabled by config.
+	hooks spanHooks
+}
+
+// HookStats counts the spans of the commands sent through a traced client. It is
+// set with WithHookStats and is safe for concurrent use.
+type HookStats struct {
+	created int64
+	skipped int64
+	errored int64
+}
+
+// SpansCreated returns the number of spans created for sent commands.
+func (s *HookStats) SpansCreated() int64 { return atomic.LoadInt64(&s.created) }
+
+// SpansSkipped returns the number of commands which were sent without a span, for
+// instance because they are ignored with WithIgnoredCommands.
+func (s *HookStats) SpansSkipped() int64 { return atomic.LoadInt64(&s.skipped) }
+
+// SpansErrored returns the number of spans which were finished with an error.
+func (s *HookStats) SpansErrored() int64 { return atomic.LoadInt64(&s.errored) }
+
+// The following methods increment the counters of s, if s is not nil.
+func (s *HookStats) spanCreated() {
+	if s != nil {
+		atomic.AddInt64(&s.created, 1)
+	}
+}
+
+func (s *HookStats) spanSkipped() {
+	if s != nil {
+		atomic.AddInt64(&s.skipped, 1)
+	}
+}
+
+func (s *HookStats) spanErrored() {
+	if s != nil {
+		atomic.AddInt64(&s.errored, 1)
+	}
+}
+
+// NewClient returns a new rueidis.Client that is traced with the default tracer under
+// the service name of the tracer, or "redis.client" when there is none. The client
+// name set in option, if any, is used as if given with WithClientName, and its
+// selected database as if given with WithDatabaseIndex.
+func NewClient(option rueidis.ClientOption, opts ...ClientOption) (rueidis.Client, error) {
+	if option.ClientName != "" {
+		opts = append([]ClientOption{WithClientName(option.ClientName)}, opts...)
+	}
+	if option.ReplicaOnly {
+		opts = append([]ClientOption{WithReadPolicyTag("replica")}, opts...)
+	}
+	opts = append([]ClientOption{WithDatabaseIndex(option.SelectDB)}, opts...)
+	cfg := newConfig(opts...)
+	client, err := rueidis.NewClient(withConnectSpans(withClientInfo(option, cfg), cfg))
+	if err != nil {
+		return nil, err
+	}
+	return wrapClient(client, cfg), nil
+}
+
+// withClientInfo returns option with its ClientSetInfo set to the library name
+// naming the service of the spans, when WithClientInfoTrace is set in cfg and no
+// ClientSetInfo is set yet.
+func withClientInfo(option rueidis.ClientOption, cfg *clientConfig) rueidis.ClientOption {
+	if !cfg.clientInfoTrace || len(option.ClientSetInfo) != 0 {
+		return option
+	}
+	// the library name can't contain spaces
+	service := strings.Join(strings.Fields(cfg.serviceName), "_")
+	option.ClientSetInfo = []string{rueidis.LibName + "(dd-trace-go_" + service + ")", rueidis.LibVer}
+	return option
+}
+
+// WrapClient returns a rueidis.Client wrapping the given client with a hook that traces
+// with the default tracer under the service name of the tracer, or "redis.client"
+// when there is none.
+func WrapClient(client rueidis.Client, opts ...ClientOption) rueidis.Client {
+	return wrapClient(client, newConfig(opts...))
+}
+
+// newConfig returns the configuration of a client with the given options,
+// applied once over the defaults.
+func newConfig(opts ...ClientOption) *clientConfig {
+	cfg := new(clientConfig)
+	defaults(cfg)
+	for _, fn := range opts {
+		fn(cfg)
+	}
+	validate(cfg)
+	return cfg
+}
+
+// wrapClient returns client wrapped with a hook tracing with the given
+// configuration.
+func wrapClient(client rueidis.Client, cfg *clientConfig) rueidis.Client {
+	hookParams := &params{
+		additionalTags: additionalTagOptions(client),
+		config:         cfg,
+	}
+
+	if cfg.transportTag {
+		hookParams.additionalTags = append(hookParams.additionalTags, tracer.Tag(TagTransport, transport(client)))
+	}
+	if cfg.serverVersionTag {
+		hookParams.serverVersion = fetchServerVersion(client)
+	}
+	if cfg.respVersionTag {
+		hookParams.respVersion = fetchRESPVersion(client)
+	}
+	if cfg.failoverDetection {
+		hookParams.failover = new(failoverDetector)
+	}
+	if cfg.aggregationWindow > 0 {
+		hookParams.aggregator = newAggregator(cfg.aggregationWindow, cfg.aggregationMax)
+	}
+	if cfg.debugRingSize > 0 {
+		hookParams.commandRing = newCommandRing(cfg.debugRingSize)
+	}
+	if cfg.serverStatsInterval > 0 {
+		hookParams.serverStats = startServerStats(client, cfg.serverStatsInterval)
+	}
+	ddh := &datadogHook{params: hookParams}
+	hookParams.hooks = newSpanHooks(ddh)
+	traced := rueidishook.WithHook(client, ddh)
+	if hookParams.serverStats != nil {
+		traced = &serverStatsClient{Client: traced, stats: hookParams.serverStats}
+	}
+	if hookParams.commandRing != nil {
+		traced = &debugClient{Client: traced, ring: hookParams.commandRing}
+	}
+	return traced
+}
+
+func additionalTagOptions(client rueidis.Client) []ddtrace.StartSpanOption {
+	addrs := []string{}
+	for addr := range client.Nodes() {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+
+	additionalTags := []ddtrace.StartSpanOption{}
+	if len(addrs) == 1 {
+		additionalTags = targetTags(addrs[0])
+	} else if len(addrs) > 1 {
+		additionalTags = []ddtrace.StartSpanOption{
+			tracer.Tag(TagAddrs, strings.Join(addrs, ", ")),
+		}
+	}
+	additionalTags = append(additionalTags,
+		tracer.SpanType(ext.SpanTypeRedis),
+		tracer.Tag(ext.Component, componentName),
+		tracer.Tag(ext.SpanKind, ext.SpanKindClient),
+		tracer.Tag(ext.DBSystem, ext.DBSystemRedis),
+	)
+	return additionalTags
+}
+
+// targetTags returns the tags of the target host and port of the spans of the
+// commands sent to the server with the given address. The host of a unix socket
+// is its path, and it has no port.
+func targetTags(addr string) []ddtrace.StartSpanOption {
+	if path, ok := unixSocketPath(addr); ok {
+		return []ddtrace.StartSpanOption{tracer.Tag(ext.TargetHost, path)}
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+		port = "6379"
+	}
+	return []ddtrace.StartSpanOption{
+		tracer.Tag(ext.TargetHost, host),
+		tracer.Tag(ext.TargetPort, port),
+	}
+}
+
+// unixSocketPath returns the path of the unix socket with the given address,
+// which is either an absolute path, such as "/var/run/redis.sock", or a
+// "unix://" URL. rueidis dials such addresses over TCP, so they are assumed to
+// be dialed by a custom DialFn, see WithTransportTag.
+func unixSocketPath(addr string) (string, bool) {
+	if strings.HasPrefix(addr, "unix://") {
+		return strings.TrimPrefix(addr, "unix://"), true
+	}
+	return addr, strings.HasPrefix(addr, "/")
+}
+
+// transport returns the value of the "redis.transport" tag of the spans of the
+// commands sent through client: "unix" when all its nodes are unix sockets, and
+// "tcp" otherwise.
+func transport(client rueidis.Client) string {
+	nodes := client.Nodes()
+	if len(nodes) == 0 {
+		return "tcp"
+	}
+	for addr := range nodes {
+		if _, ok := unixSocketPath(addr); !ok {
+			return "tcp"
+		}
+	}
+	return "unix"
+}
+
+func (ddh *datadogHook) Do(client rueidis.Client, ctx context.Context, cmd rueidis.Completed) rueidis.RedisResult {
+	span, ctx := ddh.start(ctx, client, resourceName(cmd.Commands()), cmd.Commands())
+	ddh.setTrackingTag(span, false)
+	resp := client.Do(ctx, cmd)
+	ddh.setRetryTag(span, resp)
+	ddh.setResultTypeTag(span, resp)
+	setTransactionAbortedTag(span, resp)
+	ddh.setReplyTags(span, resp)
+	ddh.end(span, resp.Error())
+	return resp
+}
+
+func (ddh *datadogHook) DoMulti(client rueidis.Client, ctx context.Context, multi ...rueidis.Completed) []rueidis.RedisResult {
+	cmds := make([][]string, 0, len(multi))
+	for i := range multi {
+		cmds = append(cmds, multi[i].Commands())
+	}
+	span, ctx := ddh.start(ctx, client, pipelineResource, cmds...)
+	ddh.setTrackingTag(span, false)
+	resps := client.DoMulti(ctx, multi...)
+	ddh.setRetryTag(span, resps...)
+	setTransactionAbortedTag(span, resps...)
+	ddh.end(span, firstError(resps))
+	return resps
+}
+
+func (ddh *datadogHook) DoCache(client rueidis.Client, ctx context.Context, cmd rueidis.Cacheable, ttl time.Duration) rueidis.RedisResult {
+	span, ctx := ddh.start(ctx, client, resourceName(cmd.Commands()), cmd.Commands())
+	ddh.setTrackingTag(span, true)
+	ddh.setCachedResource(span)
+	resp := client.DoCache(ctx, cmd, ttl)
+	ddh.setRetryTag(span, resp)
+	ddh.setResultTypeTag(span, resp)
+	if span != nil {
+		span.SetTag(TagCacheHit, ddh.cacheHit(resp))
+	}
+	ddh.setCacheSizeTag(span, resp)
+	ddh.setReplyTags(span, resp)
+	ddh.end(span, resp.Error())
+	return resp
+}
+
+func (ddh *datadogHook) DoMultiCache(client rueidis.Client, ctx context.Context, multi ...rueidis.CacheableTTL) []rueidis.RedisResult {
+	cmds := make([][]string, 0, len(multi))
+	for i := range multi {
+		cmds = append(cmds, multi[i].Cmd.Commands())
+	}
+	span, ctx := ddh.start(ctx, client, pipelineResource, cmds...)
+	ddh.setTrackingTag(span, true)
+	ddh.setCachedResource(span)
+	resps := client.DoMultiCache(ctx, multi...)
+	ddh.setRetryTag(span, resps...)
+	hit := len(resps) > 0
+	for _, resp := range resps {
+		hit = hit && ddh.cacheHit(resp)
+	}
+	if span != nil {
+		span.SetTag(TagCacheHit, hit)
+	}
+	ddh.setCacheSizeTag(span, resps...)
+	ddh.end(span, firstError(resps))
+	return resps
+}
+
+func (ddh *datadogHook) Receive(client rueidis.Client, ctx context.Context, subscribe rueidis.Completed, fn func(msg rueidis.PubSubMessage)) error {
+	span, ctx := ddh.start(ctx, client, resourceName(subscribe.Commands()), subscribe.Commands())
+	ddh.setTrackingTag(span, false)
+	// the span is finished as soon as fn panics, since the panic may not
+	// unwind through this function when fn is called from another goroutine
+	var once sync.Once
+	finish := func(err error) {
+		once.Do(func() { ddh.end(span, err) })
+	}
+	err := client.Receive(ctx, subscribe, func(msg rueidis.PubSubMessage) {
+		var msgSpan ddtrace.Span
+		if span != nil && ddh.config.messageSpans {
+			msgSpan = ddh.startMessageSpan(ctx, client, msg)
+		}
+		defer func() {
+			if r := recover(); r != nil {
+				err := fmt.Errorf("panic in Receive callback: %v", r)
+				if msgSpan != nil {
+					msgSpan.Finish(tracer.WithError(err))
+				}
+				finish(err)
+				panic(r)
+			}
+			if msgSpan != nil {
+				msgSpan.Finish()
+			}
+		}()
+		fn(msg)
+	})
+	finish(err)
+	return err
+}
+
+// setTrackingTag sets the "redis.tracking" tag on span, if enabled, reporting
+// whether the commands opted into the client side caching, which relies on the
+// RESP3 client tracking of the server.
+func (ddh *datadogHook) setTrackingTag(span *commandSpan, tracking bool) {
+	if span == nil || !ddh.config.trackingTag {
+		return
+	}
+	span.SetTag(TagTracking, tracking)
+}
+
+// setCachedResource appends the " (cached)" suffix to the resource name of span,
+// if enabled, for the commands sent with DoCache or DoMultiCache.
+func (ddh *datadogHook) setCachedResource(span *commandSpan) {
+	if span == nil || !ddh.config.cachedSuffix {
+		return
+	}
+	span.resource += " (cached)"
+	span.SetTag(ext.ResourceName, span.resource)
+}
+
+// execIndex returns the index of the last EXEC command among the given
+// commands when WithTransactionAbortedTag is set, or -1.
+func (ddh *datadogHook) execIndex(cmds ...[]string) int {
+	if !ddh.config.txAbortedTag {
+		return -1
+	}
+	for i := len(cmds) - 1; i >= 0; i-- {
+		if commandVerb(cmds[i]) == "EXEC" {
+			return i
+		}
+	}
+	return -1
+}
+
+// setTransactionAbortedTag sets the "redis.transaction_aborted" tag on the span
+// of commands including an EXEC command, reporting whether its reply is nil,
+// which is the case when the transaction is aborted because a WATCHed key was
+// modified.
+func setTransactionAbortedTag(span *commandSpan, resps ...rueidis.RedisResult) {
+	if span == nil || span.exec < 0 || span.exec >= len(resps) {
+		return
+	}
+	span.SetTag(TagTransactionAborted, rueidis.IsRedisNil(resps[span.exec].Error()))
+}
+
+// setResultTypeTag sets the "redis.result_type" tag on span, if enabled, to the
+// RESP type of the reply of its command.
+func (ddh *datadogHook) setResultTypeTag(span *commandSpan, resp rueidis.RedisResult) {
+	if span == nil || !ddh.config.resultTypeTag {
+		return
+	}
+	if typ, ok := resultType(resp); ok {
+		span.SetTag(TagResultType, typ)
+	}
+}
+
+// setReplyTags sets on span the tags extracted from the reply of its command by
+// the function set with WithReplyTagExtractor, if any. The function panicking,
+// such as when converting an errored reply, doesn't fail the command.
+func (ddh *datadogHook) setReplyTags(span *commandSpan, resp rueidis.RedisResult) {
+	fn := ddh.config.replyTagExtractor
+	if span == nil || fn == nil {
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			log.Debug("contrib/redis/rueidis: recovered from the reply tag extractor panicking: %v", r)
+		}
+	}()
+	var cfg ddtrace.StartSpanConfig
+	for _, opt := range fn(span.verb, resp) {
+		opt(&cfg)
+	}
+	for k, v := range cfg.Tags {
+		span.SetTag(k, v)
+	}
+}
+
+// setCacheSizeTag sets the "redis.cache_entry_bytes" tag on span, if enabled, to
+// the sum of the sizes of the given replies, skipping the errors. Nil replies
+// are cached too, with a size of zero.
+func (ddh *datadogHook) setCacheSizeTag(span *commandSpan, resps ...rueidis.RedisResult) {
+	if span == nil || !ddh.config.cacheSizeTag {
+		return
+	}
+	size, ok := 0, false
+	for _, resp := range resps {
+		msg, err := resp.ToMessage()
+		if err != nil && !rueidis.IsRedisNil(err) {
+			continue
+		}
+		size += messageSize(msg)
+		ok = true
+	}
+	if ok {
+		span.SetTag(TagCacheEntryBytes, size)
+	}
+}
+
+// messageSize returns the approximate size in bytes of the given reply: the
+// length of its strings, 8 bytes per number and 1 per boolean, summed over the
+// elements of the arrays and the keys and values of the maps.
+func messageSize(msg rueidis.RedisMessage) int {
+	switch {
+	case msg.IsString():
+		s, _ := msg.ToString()
+		return len(s)
+	case msg.IsInt64(), msg.IsFloat64():
+		return 8
+	case msg.IsBool():
+		return 1
+	case msg.IsArray():
+		values, _ := msg.ToArray()
+		size := 0
+		for _, v := range values {
+			size += messageSize(v)
+		}
+		return size
+	case msg.IsMap():
+		m, _ := msg.ToMap()
+		size := 0
+		for k, v := range m {
+			size += len(k) + messageSize(v)
+		}
+		return size
+	default:
+		return 0
+	}
+}
+
+// resultType returns the RESP type of the given reply, when it is known.
+func resultType(resp rueidis.RedisResult) (string, bool) {
+	msg, err := resp.ToMessage()
+	switch {
+	case rueidis.IsRedisNil(err):
+		return "null", true
+	case err != nil:
+		return "error", true
+	case msg.IsString():
+		return "string", true
+	case msg.IsInt64():
+		return "integer", true
+	case msg.IsFloat64():
+		return "double", true
+	case msg.IsBool():
+		return "boolean", true
+	case msg.IsArray():
+		return "array", true
+	case msg.IsMap():
+		return "map", true
+	default:
+		return "", false
+	}
+}
+
+// setRetryTag sets the "redis.retries" tag on span, if enabled, to the number of
+// resps which are errors the client is expected to retry on: MOVED and ASK
+// redirects, TRYAGAIN and CLUSTERDOWN.
+func (ddh *datadogHook) setRetryTag(span *commandSpan, resps ...rueidis.RedisResult) {
+	if span == nil || !ddh.config.retryTag {
+		return
+	}
+	retries := 0
+	for _, resp := range resps {
+		if retryable(resp.Error()) {
+			retries++
+		}
+	}
+	span.SetTag(TagRetries, retries)
+}
+
+// retryable reports whether err is a redis error which the client retries on.
+func retryable(err error) bool {
+	rerr, ok := rueidis.IsRedisErr(err)
+	if !ok {
+		return false
+	}
+	if _, moved := rerr.IsMoved(); moved {
+		return true
+	}
+	if _, ask := rerr.IsAsk(); ask {
+		return true
+	}
+	return rerr.IsTryAgain() || rerr.IsClusterDown()
+}
+
+// cacheHit reports whether resp is to be tagged as a cache hit.
+func (ddh *datadogHook) cacheHit(resp rueidis.RedisResult) bool {
+	if ddh.config.emptyReplyAsMiss && emptyReply(resp) {
+		return false
+	}
+	return resp.IsCacheHit()
+}
+
+// emptyReply reports whether resp is a nil reply or an empty array reply.
+func emptyReply(resp rueidis.RedisResult) bool {
+	if rueidis.IsRedisNil(resp.Error()) {
+		return true
+	}
+	msg, err := resp.ToMessage()
+	if err != nil || !msg.IsArray() {
+		return false
+	}
+	arr, err := msg.ToArray()
+	return err == nil && len(arr) == 0
+}
+
+// commandSpan is the span of traced commands, along with what is needed to
+// finish it.
+type commandSpan struct {
+	ddtrace.Span
+	// verb is the uppercased verb of the command, or pipelineResource for the
+	// commands sent together.
+	verb string
+	// resource is the resource name of the span.
+	resource string
+	start    time.Time
+	// exec is the index of the EXEC command among the commands, or -1 when there
+	// is none or when WithTransactionAbortedTag isn't set.
+	exec int
+	// overhead is the time spent starting the span, see WithHookOverheadTag.
+	overhead time.Duration
+	// group is the group of commands the span represents, see WithAggregation.
+	// follower is true when the command isn't the first one of the group, in
+	// which case the span is a followerSpan.
+	group    *aggregateGroup
+	follower bool
+	// baselineRTT is the expected round trip time to the node serving the
+	// command, when known, see WithBaselineRTT.
+	baselineRTT    time.Duration
+	hasBaselineRTT bool
+	// allocs is the number of bytes allocated on the heap when the command was
+	// sent, if its allocations are tracked, see WithAllocTracking.
+	allocs      uint64
+	trackAllocs bool
+	// generations reports the generation of the connection of the client when
+	// the reconnections are detected, with the generation when the command was
+	// sent, see WithReconnectDetection.
+	generations connectionGenerationReporter
+	generation  uint64
+}
+
+// start starts a span for the given commands. The commands must not be read
+// once they are handed over to the client, since rueidis recycles them, so
+// anything the span needs is extracted here. The returned context carries the
+// span as the active one and must be passed down to the client call: this is
+// what allows the span to be correlated with the profiler's code hotspots.
+// When the commands must not be traced, the returned span is nil and ctx is
+// returned unchanged.
+func (ddh *datadogHook) start(ctx context.Context, client rueidis.Client, resource string, cmds ...[]string) (*commandSpan, context.Context) {
+	p := ddh.params
+	var begin time.Time
+	if p.config.hookOverheadTag {
+		begin = time.Now()
+	}
+	spanResource := ddh.spanResource(resource, cmds...)
+	if ddh.ignored(cmds...) || p.config.excludedResources[spanResource] || ddh.orphan(ctx) || noTrace(ctx) {
+		p.config.hookStats.spanSkipped()
+		return nil, ctx
+	}
+	var parent uint64
+	aggregated := p.aggregator != nil && resource != pipelineResource && len(cmds) == 1
+	if aggregated {
+		parent, aggregated = ddh.parentSpanID(ctx)
+	}
+	if aggregated {
+		if g := p.aggregator.join(commandVerb(cmds[0]), parent); g != nil {
+			p.config.hookStats.spanSkipped()
+			return &commandSpan{
+				Span:     followerSpan{group: g},
+				verb:     g.key.verb,
+				resource: spanResource,
+				start:    p.config.now(),
+				exec:     -1,
+				group:    g,
+				follower: true,
+			}, ctx
+		}
+	}
+	startOpts := make([]ddtrace.StartSpanOption, 0, 3+1+len(ddh.additionalTags)+1) // 3 options below + redis.raw_command + ddh.additionalTags + analyticsRate
+	cs := &commandSpan{
+		verb:     pipelineResource,
+		resource: spanResource,
+		start:    p.config.now(),
+		exec:     ddh.execIndex(cmds...),
+	}
+	if resource != pipelineResource && len(cmds) == 1 {
+		cs.verb = commandVerb(cmds[0])
+	}
+	startOpts = append(startOpts,
+		tracer.ServiceName(ddh.serviceName(client)),
+		tracer.ResourceName(cs.resource),
+		tracer.StartTime(cs.start),
+	)
+	if len(cmds) == 1 {
+		startOpts = append(startOpts, tracer.Tag(TagArgsLength, strconv.Itoa(len(cmds[0])-1)))
+		if ddh.blocking(cmds[0]) {
+			startOpts = append(startOpts, tracer.Tag(TagBlocking, true))
+		}
+		if name, ok := ddh.function(cmds[0]); ok {
+			startOpts = append(startOpts, tracer.Tag(TagFunction, name))
+		}
+		if n, ok := channelCount(cmds[0]); ok {
+			startOpts = append(startOpts, tracer.Tag(TagChannels, n))
+		}
+	}
+	if resource == pipelineResource {
+		// rueidis doesn't record when the commands were built, so only the size of
+		// the pipeline is known and not how long the commands were queued for
+		startOpts = append(startOpts, tracer.Tag(TagPipelineSize, len(cmds)))
+	}
+	// spans are sampled by span ID, so the raw command of sampled spans is only
+	// set once the span is started, but it must be built before the commands are
+	// recycled
+	var raw string
+	rawRate := p.config.rawSampleRate
+	if !p.config.skipRaw && rawRate > 0 {
+		raw = ddh.rawCommand(cmds...)
+		if rawRate >= 1 {
+			startOpts = append(startOpts, tracer.Tag(TagRawCommand, raw))
+		}
+	}
+	c := tracedCommand{ctx: ctx, client: client, resource: resource, cmds: cmds, span: cs}
+	for _, hook := range p.hooks.start {
+		startOpts = hook(c, startOpts)
+	}
+	rate := p.config.analyticsRate
+	if r, ok := ddh.keyPrefixRate(cmds...); ok {
+		rate = r
+	}
+	if !math.IsNaN(rate) {
+		startOpts = append(startOpts, tracer.Tag(ext.EventSampleRate, rate))
+	}
+	cs.Span, ctx = ddh.startSpan(ctx, startOpts...)
+	if !p.config.skipRaw && rawRate > 0 && rawRate < 1 && sampledByRate(cs.Context().SpanID(), rawRate) {
+		cs.SetTag(TagRawCommand, raw)
+	}
+	if aggregated {
+		p.aggregator.open(cs, parent)
+	}
+	for _, hook := range p.hooks.started {
+		hook(cs)
+	}
+	p.config.hookStats.spanCreated()
+	if p.config.hookOverheadTag {
+		cs.overhead = time.Since(begin)
+	}
+	return cs, ctx
+}
+
+// startSpan starts a span as a child of the span context returned by the
+// extractor set with WithSpanContextExtractor, if any, or of the span found in
+// ctx otherwise, with the tracer set with WithTracer or the global tracer
+// otherwise.
+func (ddh *datadogHook) startSpan(ctx context.Context, opts ...ddtrace.StartSpanOption) (ddtrace.Span, context.Context) {
+	t := ddh.config.tracer
+	parent, extracted := ddh.extractSpanContext(ctx)
+	if t == nil && !extracted {
+		return tracer.StartSpanFromContext(ctx, ddh.config.spanName, opts...)
+	}
+	if !extracted {
+		if s, ok := tracer.SpanFromContext(ctx); ok {
+			parent = s.Context()
+		}
+	}
+	if parent != nil {
+		opts = append(opts, tracer.ChildOf(parent))
+	}
+	var span ddtrace.Span
+	if t == nil {
+		span = tracer.StartSpan(ddh.config.spanName, opts...)
+	} else {
+		span = t.StartSpan(ddh.config.spanName, opts...)
+	}
+	return span, tracer.ContextWithSpan(ctx, span)
+}
+
+// parentSpanID returns the id of the span the span of a command sent with ctx
+// would be the child of, if any.
+func (ddh *datadogHook) parentSpanID(ctx context.Context) (uint64, bool) {
+	if sc, ok := ddh.extractSpanContext(ctx); ok {
+		return sc.SpanID(), true
+	}
+	if s, ok := tracer.SpanFromContext(ctx); ok {
+		return s.Context().SpanID(), true
+	}
+	return 0, false
+}
+
+// extractSpanContext returns the span context returned for ctx by the extractor
+// set with WithSpanContextExtractor, if any.
+func (ddh *datadogHook) extractSpanContext(ctx context.Context) (ddtrace.SpanContext, bool) {
+	if ddh.config.spanCtxExtractor == nil {
+		return nil, false
+	}
+	sc := ddh.config.spanCtxExtractor(ctx)
+	return sc, sc != nil
+}
+
+// spanResource returns the resource name of the span of the given commands, which
+// is resource followed by the name of the called function when it is set with
+// WithFunctionResource, and by the normalized first key of the command when it is
+// set with WithResourceFromFirstKey.
+func (ddh *datadogHook) spanResource(resource string, cmds ...[]string) string {
+	if len(cmds) != 1 {
+		return resource
+	}
+	if name, ok := ddh.function(cmds[0]); ok {
+		resource += " " + name
+	}
+	if ddh.config.resourceKey == nil {
+		return resource
+	}
+	key, ok := firstKey(cmds[0])
+	if !ok {
+		return resource
+	}
+	if key = ddh.config.resourceKey(ddh.redactKey(key)); key == "" {
+		return resource
+	}
+	return resource + " " + key
+}
+
+// function returns the name of the function called by cmd when it is a FCALL or
+// FCALL_RO command and WithFunctionResource is set.
+func (ddh *datadogHook) function(cmd []string) (string, bool) {
+	if !ddh.config.functionResource || len(cmd) < 2 {
+		return "", false
+	}
+	if verb := commandVerb(cmd); verb != "FCALL" && verb != "FCALL_RO" {
+		return "", false
+	}
+	return cmd[1], true
+}
+
+// keyPrefixRate returns the Trace Analytics sampling rate set with
+// WithKeyPrefixSampling for the longest prefix of the first key of the given
+// command. Commands sent together are not matched.
+func (ddh *datadogHook) keyPrefixRate(cmds ...[]string) (float64, bool) {
+	if len(ddh.config.keyPrefixRates) == 0 || len(cmds) != 1 {
+		return 0, false
+	}
+	key, ok := firstKey(cmds[0])
+	if !ok {
+		return 0, false
+	}
+	var (
+		rate    float64
+		matched = -1
+	)
+	for prefix, r := range ddh.config.keyPrefixRates {
+		if len(prefix) > matched && strings.HasPrefix(key, prefix) {
+			rate, matched = r, len(prefix)
+		}
+	}
+	return rate, matched >= 0
+}
+
+// heapAllocsMetric is the runtime metric of the cumulative number of bytes
+// allocated on the heap, which unlike runtime.ReadMemStats doesn't stop the world.
+const heapAllocsMetric = "/gc/heap/allocs:bytes"
+
+// heapAllocs returns the cumulative number of bytes allocated on the heap by the
+// process.
+func heapAllocs() uint64 {
+	sample := []metrics.Sample{{Name: heapAllocsMetric}}
+	metrics.Read(sample)
+	if sample[0].Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+	return sample[0].Value.Uint64()
+}
+
+// allocDelta returns the number of bytes allocated on the heap between the given
+// samples of heapAllocs. It reports false when either sample is zero, which is
+// the case when the metric isn't supported, or when they go backwards.
+func allocDelta(start, end uint64) (int64, bool) {
+	if start == 0 || end == 0 || end < start {
+		return 0, false
+	}
+	return int64(end - start), true
+}
+
+// knuthFactor is the multiplier used to spread the span IDs sampled by
+// sampledByRate, as done by the tracer's samplers.
+const knuthFactor = uint64(1111111111111111111)
+
+// sampledByRate reports whether the span with the given ID is kept when sampling
+// at rate. The decision is deterministic for a given span ID.
+func sampledByRate(spanID uint64, rate float64) bool {
+	if rate >= 1 {
+		return true
+	}
+	return spanID*knuthFactor < uint64(rate*math.MaxUint64)
+}
+
+// blocking reports whether cmd is a blocking command. XREAD and XREADGROUP
+// only block when given the BLOCK argument.
+func (ddh *datadogHook) blocking(cmd []string) bool {
+	verb := commandVerb(cmd)
+	if !ddh.config.blockingCommands[verb] {
+		return false
+	}
+	if verb != "XREAD" && verb != "XREADGROUP" {
+		return true
+	}
+	for _, arg := range cmd[1:] {
+		if strings.EqualFold(arg, "BLOCK") {
+			return true
+		}
+	}
+	return false
+}
+
+// redactKey returns key with the parts matching the redactor set with
+// WithKeyRedactor replaced, if any.
+func (ddh *datadogHook) redactKey(key string) string {
+	if ddh.config.keyRedactor == nil {
+		return key
+	}
+	return ddh.config.keyRedactor.ReplaceAllString(key, ddh.config.keyReplacement)
+}
+
+// SpanIDsFromContext returns the trace and span ids of the span found in ctx, or
+// zeros when there is none. The commands are sent down to the wrapped client with
+// a context holding their span, so when called with the context given to the hooks
+// or clients wrapped by WrapClient, such as a logging hook, it returns the ids of
+// the span of the command.
+func SpanIDsFromContext(ctx context.Context) (traceID, spanID uint64) {
+	span, ok := tracer.SpanFromContext(ctx)
+	if !ok {
+		return 0, 0
+	}
+	return span.Context().TraceID(), span.Context().SpanID()
+}
+
+type noTraceKey struct{}
+
+// WithNoTrace returns a copy of ctx which disables tracing of the commands sent
+// with it, for instance in a tight loop which would otherwise produce too many
+// spans.
+func WithNoTrace(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noTraceKey{}, true)
+}
+
+// noTrace reports whether tracing is disabled in ctx with WithNoTrace.
+func noTrace(ctx context.Context) bool {
+	v, _ := ctx.Value(noTraceKey{}).(bool)
+	return v
+}
+
+// orphan reports whether the span of a command sent with ctx must be skipped
+// because it would be a root span, when enabled with WithOnlyWithinTrace.
+func (ddh *datadogHook) orphan(ctx context.Context) bool {
+	if !ddh.config.onlyWithinTrace {
+		return false
+	}
+	if _, ok := ddh.extractSpanContext(ctx); ok {
+		return false
+	}
+	_, ok := tracer.SpanFromContext(ctx)
+	return !ok
+}
+
+// ignored reports whether all the given commands have a verb which must not be traced.
+func (ddh *datadogHook) ignored(cmds ...[]string) bool {
+	if len(ddh.config.ignoredCommands) == 0 || len(cmds) == 0 {
+		return false
+	}
+	for _, cmd := range cmds {
+		if len(cmd) == 0 || !ddh.config.ignoredCommands[strings.ToUpper(cmd[0])] {
+			return false
+		}
+	}
+	return true
+}
+
+// serviceName returns the service name of the spans of commands sent through client.
+func (ddh *datadogHook) serviceName(client rueidis.Client) string {
+	if ddh.config.shardService != nil {
+		if node, ok := nodeAddr(client); ok {
+			if name := ddh.config.shardService(node); name != "" {
+				return name
+			}
+		}
+	}
+	return ddh.config.serviceName
+}
+
+// nodeAddr returns the address of the node client is bound to, if it is bound to a single one.
+func nodeAddr(client rueidis.Client) (string, bool) {
+	if _, ok := client.(rueidis.DedicatedClient); ok {
+		// dedicated clients are handed over to the hook as rueidis.Client, but
+		// panic when calling Nodes()
+		return "", false
+	}
+	nodes := client.Nodes()
+	if len(nodes) != 1 {
+		return "", false
+	}
+	for addr := range nodes {
+		return addr, true
+	}
+	return "", false
+}
+
+// connectionIdentifier is implemented by the clients able to identify the
+// connection serving their commands.
+type connectionIdentifier interface {
+	ConnectionID() string
+}
+
+// connectionID returns the identifier of the connection serving the commands of
+// the given client, when it exposes one. The clients of rueidis do not currently
+// expose their connections to the hooks, so it is only known for the clients
+// implementing connectionIdentifier.
+func connectionID(client rueidis.Client) (string, bool) {
+	c, ok := client.(connectionIdentifier)
+	if !ok {
+		return "", false
+	}
+	id := c.ConnectionID()
+	return id, id != ""
+}
+
+// queueDepthReporter is implemented by the clients able to report the number of
+// their outstanding requests.
+type queueDepthReporter interface {
+	QueueDepth() int
+}
+
+// queueDepth returns the number of outstanding requests of the given client,
+// when it reports it. The clients of rueidis do not currently expose the depth
+// of their queues, which is internal to their pipelines, so it is only known
+// for the clients implementing queueDepthReporter.
+func queueDepth(client rueidis.Client) (int, bool) {
+	c, ok := client.(queueDepthReporter)
+	if !ok {
+		return 0, false
+	}
+	return c.QueueDepth(), true
+}
+
+// connectionGenerationReporter is implemented by the clients able to report the
+// generation of their connection, which is incremented on every reconnection.
+// The clients of rueidis do not currently expose their connections to the
+// hooks, so reconnections are only detected for the clients implementing it.
+type connectionGenerationReporter interface {
+	ConnectionGeneration() uint64
+}
+
+// end finishes the span, recording err unless it is a redis nil reply, which is
+// only recorded for the verbs set with WithNilAsErrorForVerbs, or a LOADING
+// error when WithServerLoadingTag is set. It does
+// nothing if span is nil, which is the case for commands which are not traced.
+func (ddh *datadogHook) end(span *commandSpan, err error) {
+	if span == nil {
+		return
+	}
+	var begin time.Time
+	if ddh.config.hookOverheadTag {
+		begin = time.Now()
+	}
+	finishTime := ddh.config.now()
+	finishOpts := []ddtrace.FinishOption{tracer.FinishTime(finishTime)}
+	loading := ddh.config.serverLoadingTag && isLoading(err)
+	if loading {
+		span.SetTag(TagServerLoading, true)
+	}
+	failed := err != nil && !loading && (!rueidis.IsRedisNil(err) || ddh.config.nilAsError[span.verb])
+	if failed {
+		finishOpts = append(finishOpts, tracer.WithError(err))
+		ddh.config.hookStats.spanErrored()
+	}
+	res := commandResult{err: err, failed: failed, finishTime: finishTime, begin: begin}
+	for _, hook := range ddh.hooks.end {
+		hook(span, res)
+	}
+	if span.follower {
+		var groupErr error
+		if failed {
+			groupErr = err
+		}
+		ddh.aggregator.leave(span.group, groupErr)
+	} else if span.group != nil {
+		ddh.aggregator.end(span.group, failed, finishOpts...)
+	} else {
+		span.Finish(finishOpts...)
+	}
+	for _, hook := range ddh.hooks.done {
+		hook(span, res)
+	}
+}
+
+// samplingDecision reports whether the trace of span is kept. The sampling
+// priority isn't otherwise exposed by the tracer, so it is read from the
+// propagation headers of the span, and is unknown when they don't carry it.
+func (ddh *datadogHook) samplingDecision(span ddtrace.Span) (kept bool, ok bool) {
+	inject := tracer.Inject
+	if t := ddh.config.tracer; t != nil {
+		inject = t.Inject
+	}
+	carrier := tracer.TextMapCarrier{}
+	if err := inject(span.Context(), carrier); err != nil {
+		return false, false
+	}
+	p, err := strconv.Atoi(carrier[tracer.DefaultPriorityHeader])
+	if err != nil {
+		return false, false
+	}
+	return p > 0, true
+}
+
+// hookPrefix is the prefix of the functions implementing datadogHook.
+var hookPrefix = reflect.TypeOf(datadogHook{}).PkgPath() + ".(*datadogHook)."
+
+// callerName returns the name of the function which issued the command being
+// traced, skipping the frames of this package's hook and of rueidis itself.
+func callerName() string {
+	var pcs [16]uintptr
+	// skip runtime.Callers, callerName and its start hook
+	n := runtime.Callers(3, pcs[:])
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, hookPrefix) && !strings.HasPrefix(frame.Function, "github.com/redis/rueidis") {
+			return frame.Function
+		}
+		if !more {
+			return ""
+		}
+	}
+}
+
+// goroutineID returns the identifier of the current goroutine, parsed from the
+// header of its stack trace, such as "goroutine 42 [running]:".
+func goroutineID() (uint64, bool) {
+	var buf [64]byte
+	n := runtime.Stack(buf[:], false)
+	s := strings.TrimPrefix(string(buf[:n]), "goroutine ")
+	if i := strings.IndexByte(s, ' '); i > 0 {
+		s = s[:i]
+	}
+	id, err := strconv.ParseUint(s, 10, 64)
+	return id, err == nil
+}
+
+// firstError returns the first error found in resps which is not a redis nil reply.
+func firstError(resps []rueidis.RedisResult) error {
+	for _, resp := range resps {
+		if err := resp.Error(); err != nil && !rueidis.IsRedisNil(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// isLoading reports whether err is the LOADING error replied by a server which
+// is loading its dataset in memory.
+func isLoading(err error) bool {
+	e, ok := rueidis.IsRedisErr(err)
+	return ok && strings.HasPrefix(e.Error(), "LOADING")
+}
+
+// containerCommands holds the commands which act as a container for subcommands,
+// such as "CLIENT LIST" or "CONFIG GET".
+var containerCommands = map[string]bool{
+	"ACL":      true,
+	"CLIENT":   true,
+	"CLUSTER":  true,
+	"COMMAND":  true,
+	"CONFIG":   true,
+	"FUNCTION": true,
+	"LATENCY":  true,
+	"MEMORY":   true,
+	"MODULE":   true,
+	"OBJECT":   true,
+	"PUBSUB":   true,
+	"SCRIPT":   true,
+	"SLOWLOG":  true,
+	"XGROUP":   true,
+	"XINFO":    true,
+}
+
+// keylessCommands holds the commands taking arguments which aren't keys.
+var keylessCommands = map[string]bool{
+	"AUTH":         true,
+	"ECHO":         true,
+	"FLUSHALL":     true,
+	"FLUSHDB":      true,
+	"HELLO":        true,
+	"INFO":         true,
+	"PING":         true,
+	"PSUBSCRIBE":   true,
+	"PUBLISH":      true,
+	"PUNSUBSCRIBE": true,
+	"SCAN":         true,
+	"SELECT":       true,
+	"SHUTDOWN":     true,
+	"SPUBLISH":     true,
+	"SSUBSCRIBE":   true,
+	"SUBSCRIBE":    true,
+	"SUNSUBSCRIBE": true,
+	"SWAPDB":       true,
+	"UNSUBSCRIBE":  true,
+	"WAIT":         true,
+}
+
+// scriptCommands holds the commands whose keys follow the script or function
+// and the number of keys, such as "EVAL script 1 key arg".
+var scriptCommands = map[string]bool{
+	"EVAL":       true,
+	"EVAL_RO":    true,
+	"EVALSHA":    true,
+	"EVALSHA_RO": true,
+	"FCALL":      true,
+	"FCALL_RO":   true,
+}
+
+// firstKey returns the first key of the given command. It is assumed to be the
+// first argument of the command, except for container commands and for the
+// commands known to take no key.
+func firstKey(cmd []string) (string, bool) {
+	verb := commandVerb(cmd)
+	switch {
+	case scriptCommands[verb]:
+		if len(cmd) < 4 || cmd[2] == "0" {
+			return "", false
+		}
+		return cmd[3], true
+	case len(cmd) < 2 || containerCommands[verb] || keylessCommands[verb]:
+		return "", false
+	default:
+		return cmd[1], true
+	}
+}
+
+// keyArity describes the arguments of a multi-key command: every step-th
+// argument is a key, except for the trailing non-key arguments.
+type keyArity struct {
+	step     int
+	trailing int
+}
+
+// multiKeyCommands holds the arity of the common commands taking several keys.
+var multiKeyCommands = map[string]keyArity{
+	"DEL":         {step: 1},
+	"UNLINK":      {step: 1},
+	"EXISTS":      {step: 1},
+	"TOUCH":       {step: 1},
+	"WATCH":       {step: 1},
+	"MGET":        {step: 1},
+	"PFCOUNT":     {step: 1},
+	"SDIFF":       {step: 1},
+	"SINTER":      {step: 1},
+	"SUNION":      {step: 1},
+	"SDIFFSTORE":  {step: 1},
+	"SINTERSTORE": {step: 1},
+	"SUNIONSTORE": {step: 1},
+	"PFMERGE":     {step: 1},
+	"MSET":        {step: 2},
+	"MSETNX":      {step: 2},
+	"BLPOP":       {step: 1, trailing: 1},
+	"BRPOP":       {step: 1, trailing: 1},
+	"BZPOPMIN":    {step: 1, trailing: 1},
+	"BZPOPMAX":    {step: 1, trailing: 1},
+}
+
+// idempotentCommands tells whether the common commands are idempotent, that is
+// whether sending them again after a retry has no additional effect.
+var idempotentCommands = map[string]bool{
+	"DEL":          true,
+	"EXISTS":       true,
+	"EXPIRE":       true,
+	"GET":          true,
+	"GETRANGE":     true,
+	"HDEL":         true,
+	"HEXISTS":      true,
+	"HGET":         true,
+	"HGETALL":      true,
+	"HKEYS":        true,
+	"HLEN":         true,
+	"HMGET":        true,
+	"HSET":         true,
+	"HVALS":        true,
+	"LINDEX":       true,
+	"LLEN":         true,
+	"LRANGE":       true,
+	"MGET":         true,
+	"MSET":         true,
+	"PERSIST":      true,
+	"PEXPIRE":      true,
+	"PING":         true,
+	"PTTL":         true,
+	"SADD":         true,
+	"SCARD":        true,
+	"SET":          true,
+	"SISMEMBER":    true,
+	"SMEMBERS":     true,
+	"SREM":         true,
+	"STRLEN":       true,
+	"TTL":          true,
+	"TYPE":         true,
+	"UNLINK":       true,
+	"ZCARD":        true,
+	"ZRANGE":       true,
+	"ZRANK":        true,
+	"ZREM":         true,
+	"ZSCORE":       true,
+	"APPEND":       false,
+	"DECR":         false,
+	"DECRBY":       false,
+	"GETDEL":       false,
+	"HINCRBY":      false,
+	"HINCRBYFLOAT": false,
+	"INCR":         false,
+	"INCRBY":       false,
+	"INCRBYFLOAT":  false,
+	"LMOVE":        false,
+	"LPOP":         false,
+	"LPUSH":        false,
+	"PUBLISH":      false,
+	"RPOP":         false,
+	"RPOPLPUSH":    false,
+	"RPUSH":        false,
+	"SPOP":         false,
+	"XADD":         false,
+	"ZINCRBY":      false,
+}
+
+// idempotent reports whether cmd is idempotent, according to idempotentCommands
+// and to the overrides set with WithIdempotencyTag, when it is known.
+func (ddh *datadogHook) idempotent(cmd []string) (idempotent, ok bool) {
+	verb := commandVerb(cmd)
+	if idempotent, ok = ddh.config.idempotency[verb]; ok {
+		return idempotent, true
+	}
+	idempotent, ok = idempotentCommands[verb]
+	return idempotent, ok
+}
+
+// consistency returns the consistency mode of the given commands, which is
+// "strong" when one of them is a WAIT or WAITAOF command, waiting for the writes
+// to be acknowledged by replicas or persisted, and mode otherwise.
+func consistency(mode string, cmds ...[]string) string {
+	for _, cmd := range cmds {
+		if verb := commandVerb(cmd); verb == "WAIT" || verb == "WAITAOF" {
+			return "strong"
+		}
+	}
+	return mode
+}
+
+// keysCount returns the number of keys of the given command, when it is one of
+// multiKeyCommands.
+func keysCount(cmd []string) (int, bool) {
+	arity, ok := multiKeyCommands[commandVerb(cmd)]
+	if !ok {
+		return 0, false
+	}
+	args := len(cmd) - 1 - arity.trailing
+	if args <= 0 {
+		return 0, true
+	}
+	return (args + arity.step - 1) / arity.step, true
+}
+
+// commandVerb returns the uppercased verb of the given command.
+func commandVerb(cmd []string) string {
+	if len(cmd) == 0 {
+		return ""
+	}
+	return strings.ToUpper(cmd[0])
+}
+
+// resourceName returns the resource name of the given command, which is its verb
+// followed by the subcommand for container commands. It is uppercased, so that
+// the resource doesn't depend on the casing the command was written with.
+func resourceName(cmd []string) string {
+	if len(cmd) == 0 {
+		return ""
+	}
+	verb := commandVerb(cmd)
+	if len(cmd) > 1 && containerCommands[verb] {
+		return verb + " " + strings.ToUpper(cmd[1])
+	}
+	return verb
+}
+
+// requestSize returns the total size in bytes of the given commands.
+func requestSize(cmds ...[]string) int {
+	size := 0
+	for _, cmd := range cmds {
+		for _, token := range cmd {
+			size += len(token)
+		}
+	}
+	return size
+}
+
+// rawCommand returns the value of the raw command tag of the given commands,
+// inlining at most the configured maximum number of them followed by a
+// "+N more" line counting the ones left out.
+func (ddh *datadogHook) rawCommand(cmds ...[]string) string {
+	max := ddh.config.maxRawCommands
+	if max <= 0 || len(cmds) <= max {
+		return completedToStr(cmds...)
+	}
+	return fmt.Sprintf("%s\n+%d more", completedToStr(cmds[:max]...), len(cmds)-max)
+}
+
+// completedToStr returns a string representation of the given commands, separated by newlines.
+// Redis values being binary safe, invalid UTF-8 sequences are replaced with the
+// replacement character so that the result can safely be used as a span tag.
+func completedToStr(cmds ...[]string) string {
+	var b strings.Builder
+	for i, cmd := range cmds {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString(strings.Join(cmd, " "))
+	}
+	return strings.ToValidUTF8(b.String(), "\uFFFD")
+}
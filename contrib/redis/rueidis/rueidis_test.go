@@ -0,0 +1,2397 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016 Datadog, Inc.
+
+package rueidis
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"gopkg.in/DataDog/dd-trace-go.v1/contrib/internal/namingschematest"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/mocktracer"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+
+	"github.com/golang/mock/gomock"
+	"github.com/redis/rueidis"
+	"github.com/redis/rueidis/mock"
+	"github.com/redis/rueidis/rueidishook"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ensure it's a rueidishook.Hook
+var _ rueidishook.Hook = (*datadogHook)(nil)
+
+// newMockClient returns a mocked rueidis.Client connected to a single node at
+// 127.0.0.1:6379.
+func newMockClient(t *testing.T) *mock.Client {
+	client := mock.NewClient(gomock.NewController(t))
+	client.EXPECT().Nodes().Return(map[string]rueidis.Client{"127.0.0.1:6379": client}).AnyTimes()
+	return client
+}
+
+// fakeClock is a clock which only moves when advanced, so that the durations of
+// the spans don't depend on the scheduling of the tests.
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+// withClock makes the client time its spans with c.
+func withClock(c *fakeClock) ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.now = c.now
+	}
+}
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+	assert := assert.New(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	mc := newMockClient(t)
+	mc.EXPECT().Do(gomock.Any(), mock.Match("SET", "test_key", "test_value")).Return(mock.Result(mock.RedisString("OK")))
+	client := WrapClient(mc, WithServiceName("my-redis"))
+	err := client.Do(ctx, client.B().Set().Key("test_key").Value("test_value").Build()).Error()
+	assert.NoError(err)
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 1)
+
+	span := spans[0]
+	assert.Equal("redis.command", span.OperationName())
+	assert.Equal(ext.SpanTypeRedis, span.Tag(ext.SpanType))
+	assert.Equal("my-redis", span.Tag(ext.ServiceName))
+	assert.Equal("SET", span.Tag(ext.ResourceName))
+	assert.Equal("127.0.0.1", span.Tag(ext.TargetHost))
+	assert.Equal("6379", span.Tag(ext.TargetPort))
+	assert.Equal("SET test_key test_value", span.Tag("redis.raw_command"))
+	assert.Equal("2", span.Tag("redis.args_length"))
+	assert.Equal("redis/rueidis", span.Tag(ext.Component))
+	assert.Equal(ext.SpanKindClient, span.Tag(ext.SpanKind))
+	assert.Equal("redis", span.Tag(ext.DBSystem))
+}
+
+func TestAdditionalTagsFromClient(t *testing.T) {
+	t.Run("cluster", func(t *testing.T) {
+		mc := mock.NewClient(gomock.NewController(t))
+		mc.EXPECT().Nodes().Return(map[string]rueidis.Client{
+			"127.0.0.1:7001": mc,
+			"127.0.0.1:7000": mc,
+		})
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		for _, opt := range additionalTagOptions(mc) {
+			tracer.StartSpan("test", opt).Finish()
+		}
+		tags := map[string]interface{}{}
+		for _, s := range mt.FinishedSpans() {
+			for k, v := range s.Tags() {
+				tags[k] = v
+			}
+		}
+		assert.Equal(t, "127.0.0.1:7000, 127.0.0.1:7001", tags["addrs"])
+		assert.NotContains(t, tags, ext.TargetHost)
+	})
+
+	t.Run("no-port", func(t *testing.T) {
+		mc := mock.NewClient(gomock.NewController(t))
+		mc.EXPECT().Nodes().Return(map[string]rueidis.Client{"redis.local": mc})
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		for _, opt := range additionalTagOptions(mc) {
+			tracer.StartSpan("test", opt).Finish()
+		}
+		tags := map[string]interface{}{}
+		for _, s := range mt.FinishedSpans() {
+			for k, v := range s.Tags() {
+				tags[k] = v
+			}
+		}
+		assert.Equal(t, "redis.local", tags[ext.TargetHost])
+		assert.Equal(t, "6379", tags[ext.TargetPort])
+	})
+
+	t.Run("unix-socket", func(t *testing.T) {
+		mc := mock.NewClient(gomock.NewController(t))
+		mc.EXPECT().Nodes().Return(map[string]rueidis.Client{"/var/run/redis.sock": mc})
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		for _, opt := range additionalTagOptions(mc) {
+			tracer.StartSpan("test", opt).Finish()
+		}
+		tags := map[string]interface{}{}
+		for _, s := range mt.FinishedSpans() {
+			for k, v := range s.Tags() {
+				tags[k] = v
+			}
+		}
+		assert.Equal(t, "/var/run/redis.sock", tags[ext.TargetHost])
+		assert.NotContains(t, tags, ext.TargetPort)
+	})
+}
+
+func TestResourceName(t *testing.T) {
+	for _, tt := range []struct {
+		cmd  []string
+		want string
+	}{
+		{cmd: nil, want: ""},
+		{cmd: []string{"GET", "key"}, want: "GET"},
+		{cmd: []string{"PING"}, want: "PING"},
+		{cmd: []string{"CLIENT", "LIST"}, want: "CLIENT LIST"},
+		{cmd: []string{"CONFIG", "GET", "maxmemory"}, want: "CONFIG GET"},
+		{cmd: []string{"CLIENT"}, want: "CLIENT"},
+		{cmd: []string{"get", "key"}, want: "GET"},
+		{cmd: []string{"client", "list"}, want: "CLIENT LIST"},
+	} {
+		assert.Equal(t, tt.want, resourceName(tt.cmd))
+	}
+}
+
+func TestLowercaseCommand(t *testing.T) {
+	assert := assert.New(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	mc := newMockClient(t)
+	mc.EXPECT().Do(gomock.Any(), mock.Match("get", "key")).Return(mock.Result(mock.RedisString("value")))
+	client := WrapClient(mc)
+	client.Do(context.Background(), client.B().Arbitrary("get").Keys("key").Build())
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 1)
+	assert.Equal("GET", spans[0].Tag(ext.ResourceName))
+	assert.Equal("get key", spans[0].Tag("redis.raw_command"))
+}
+
+func TestResourceFromFirstKey(t *testing.T) {
+	assert := assert.New(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	mc := newMockClient(t)
+	mc.EXPECT().Do(gomock.Any(), gomock.Any()).Return(mock.Result(mock.RedisString("OK"))).Times(5)
+	client := WrapClient(mc, WithResourceFromFirstKey(func(key string) string {
+		if prefix, _, ok := strings.Cut(key, ":"); ok {
+			return prefix + ":*"
+		}
+		return ""
+	}))
+	ctx := context.Background()
+	client.Do(ctx, client.B().Get().Key("user:123").Build())
+	client.Do(ctx, client.B().Eval().Script("return 1").Numkeys(1).Key("user:456").Build())
+	client.Do(ctx, client.B().Get().Key("counter").Build())
+	client.Do(ctx, client.B().Ping().Message("user:123").Build())
+	client.Do(ctx, client.B().ClientList().Build())
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 5)
+	assert.Equal("GET user:*", spans[0].Tag(ext.ResourceName))
+	assert.Equal("EVAL user:*", spans[1].Tag(ext.ResourceName))
+	assert.Equal("GET", spans[2].Tag(ext.ResourceName))
+	assert.Equal("PING", spans[3].Tag(ext.ResourceName))
+	assert.Equal("CLIENT LIST", spans[4].Tag(ext.ResourceName))
+}
+
+func TestPipeline(t *testing.T) {
+	ctx := context.Background()
+	assert := assert.New(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	mc := newMockClient(t)
+	mc.EXPECT().DoMulti(gomock.Any(), mock.Match("INCR", "counter"), mock.Match("EXPIRE", "counter", "3600")).Return([]rueidis.RedisResult{
+		mock.Result(mock.RedisInt64(1)),
+		mock.Result(mock.RedisInt64(1)),
+	})
+	client := WrapClient(mc, WithServiceName("my-redis"))
+	client.DoMulti(ctx,
+		client.B().Incr().Key("counter").Build(),
+		client.B().Expire().Key("counter").Seconds(3600).Build(),
+	)
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 1)
+
+	span := spans[0]
+	assert.Equal("redis.command", span.OperationName())
+	assert.Equal("my-redis", span.Tag(ext.ServiceName))
+	assert.Equal("redis.pipeline", span.Tag(ext.ResourceName))
+	assert.Equal("INCR counter\nEXPIRE counter 3600", span.Tag("redis.raw_command"))
+	assert.Nil(span.Tag("redis.args_length"))
+	assert.Equal(2, span.Tag(TagPipelineSize))
+	assert.Nil(span.Tag(ext.Error))
+	assert.Equal("redis/rueidis", span.Tag(ext.Component))
+}
+
+func TestDoCache(t *testing.T) {
+	ctx := context.Background()
+	assert := assert.New(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	mc := newMockClient(t)
+	mc.EXPECT().DoCache(gomock.Any(), mock.Match("GET", "test_key"), time.Minute).Return(mock.Result(mock.RedisString("test_value")))
+	mc.EXPECT().DoMultiCache(gomock.Any(), gomock.Any()).Return([]rueidis.RedisResult{
+		mock.Result(mock.RedisString("test_value")),
+	})
+	client := WrapClient(mc)
+	v, err := client.DoCache(ctx, client.B().Get().Key("test_key").Cache(), time.Minute).ToString()
+	assert.NoError(err)
+	assert.Equal("test_value", v)
+	client.DoMultiCache(ctx, rueidis.CT(client.B().Get().Key("test_key").Cache(), time.Minute))
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 2)
+	assert.Equal("GET", spans[0].Tag(ext.ResourceName))
+	assert.Equal("GET test_key", spans[0].Tag("redis.raw_command"))
+	assert.Equal(false, spans[0].Tag("redis.cache_hit"))
+	assert.NotContains(spans[0].Tags(), TagPipelineSize)
+	assert.Equal("redis.pipeline", spans[1].Tag(ext.ResourceName))
+	assert.Equal(1, spans[1].Tag(TagPipelineSize))
+	assert.Equal(false, spans[1].Tag("redis.cache_hit"))
+}
+
+func TestTrackingTag(t *testing.T) {
+	ctx := context.Background()
+	assert := assert.New(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	mc := newMockClient(t)
+	mc.EXPECT().Do(gomock.Any(), gomock.Any()).Return(mock.Result(mock.RedisString("test_value"))).Times(2)
+	mc.EXPECT().DoMulti(gomock.Any(), gomock.Any()).Return([]rueidis.RedisResult{
+		mock.Result(mock.RedisString("test_value")),
+	})
+	mc.EXPECT().DoCache(gomock.Any(), gomock.Any(), time.Minute).Return(mock.Result(mock.RedisString("test_value")))
+	mc.EXPECT().DoMultiCache(gomock.Any(), gomock.Any()).Return([]rueidis.RedisResult{
+		mock.Result(mock.RedisString("test_value")),
+	})
+	client := WrapClient(mc)
+	client.Do(ctx, client.B().Get().Key("test_key").Build())
+	client = WrapClient(mc, WithTrackingTag())
+	client.Do(ctx, client.B().Get().Key("test_key").Build())
+	client.DoMulti(ctx, client.B().Get().Key("test_key").Build())
+	client.DoCache(ctx, client.B().Get().Key("test_key").Cache(), time.Minute)
+	client.DoMultiCache(ctx, rueidis.CT(client.B().Get().Key("test_key").Cache(), time.Minute))
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 5)
+	assert.NotContains(spans[0].Tags(), TagTracking)
+	assert.Equal(false, spans[1].Tag(TagTracking))
+	assert.Equal(false, spans[2].Tag(TagTracking))
+	assert.Equal(true, spans[3].Tag(TagTracking))
+	assert.Equal(true, spans[4].Tag(TagTracking))
+}
+
+func TestEmptyReplyAsMiss(t *testing.T) {
+	ctx := context.Background()
+	assert := assert.New(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	mc := newMockClient(t)
+	mc.EXPECT().DoCache(gomock.Any(), mock.Match("SMEMBERS", "test_key"), time.Minute).Return(mock.Result(mock.RedisArray()))
+	mc.EXPECT().DoMultiCache(gomock.Any(), gomock.Any()).Return([]rueidis.RedisResult{
+		mock.Result(mock.RedisNil()),
+	})
+	client := WrapClient(mc, WithEmptyReplyAsMiss())
+	client.DoCache(ctx, client.B().Smembers().Key("test_key").Cache(), time.Minute)
+	client.DoMultiCache(ctx, rueidis.CT(client.B().Get().Key("test_key").Cache(), time.Minute))
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 2)
+	assert.Equal(false, spans[0].Tag("redis.cache_hit"))
+	assert.Equal(false, spans[1].Tag("redis.cache_hit"))
+
+	assert.True(emptyReply(mock.Result(mock.RedisArray())))
+	assert.True(emptyReply(mock.Result(mock.RedisNil())))
+	assert.False(emptyReply(mock.Result(mock.RedisArray(mock.RedisString("member")))))
+	assert.False(emptyReply(mock.Result(mock.RedisString(""))))
+	assert.False(emptyReply(mock.ErrorResult(errors.New("timeout"))))
+}
+
+func TestReceive(t *testing.T) {
+	ctx := context.Background()
+	assert := assert.New(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	mc := newMockClient(t)
+	mc.EXPECT().Receive(gomock.Any(), mock.Match("SUBSCRIBE", "channel"), gomock.Any()).DoAndReturn(
+		func(_ context.Context, _ rueidis.Completed, fn func(msg rueidis.PubSubMessage)) error {
+			fn(rueidis.PubSubMessage{Channel: "channel", Message: "message"})
+			return nil
+		})
+	client := WrapClient(mc)
+	var received []string
+	err := client.Receive(ctx, client.B().Subscribe().Channel("channel").Build(), func(msg rueidis.PubSubMessage) {
+		received = append(received, msg.Message)
+	})
+	assert.NoError(err)
+	assert.Equal([]string{"message"}, received)
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 1)
+	assert.Equal("SUBSCRIBE", spans[0].Tag(ext.ResourceName))
+	assert.Equal("SUBSCRIBE channel", spans[0].Tag("redis.raw_command"))
+}
+
+func TestReceivePanic(t *testing.T) {
+	ctx := context.Background()
+	assert := assert.New(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	mc := newMockClient(t)
+	mc.EXPECT().Receive(gomock.Any(), mock.Match("SUBSCRIBE", "channel"), gomock.Any()).DoAndReturn(
+		func(_ context.Context, _ rueidis.Completed, fn func(msg rueidis.PubSubMessage)) error {
+			fn(rueidis.PubSubMessage{Channel: "channel", Message: "message"})
+			return nil
+		})
+	client := WrapClient(mc)
+	assert.PanicsWithValue("boom", func() {
+		client.Receive(ctx, client.B().Subscribe().Channel("channel").Build(), func(msg rueidis.PubSubMessage) {
+			panic("boom")
+		})
+	})
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 1)
+	assert.Equal("SUBSCRIBE", spans[0].Tag(ext.ResourceName))
+	assert.EqualError(spans[0].Tag(ext.Error).(error), "panic in Receive callback: boom")
+}
+
+func TestError(t *testing.T) {
+	t.Run("error", func(t *testing.T) {
+		ctx := context.Background()
+		assert := assert.New(t)
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		wantErr := errors.New("connection refused")
+		mc := newMockClient(t)
+		mc.EXPECT().Do(gomock.Any(), mock.Match("GET", "key")).Return(mock.ErrorResult(wantErr))
+		client := WrapClient(mc)
+		err := client.Do(ctx, client.B().Get().Key("key").Build()).Error()
+		assert.Equal(wantErr, err)
+
+		spans := mt.FinishedSpans()
+		require.Len(t, spans, 1)
+		assert.Equal(wantErr, spans[0].Tag(ext.Error))
+	})
+
+	t.Run("nil", func(t *testing.T) {
+		ctx := context.Background()
+		assert := assert.New(t)
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		mc := newMockClient(t)
+		mc.EXPECT().Do(gomock.Any(), mock.Match("GET", "non_existent_key")).Return(mock.Result(mock.RedisNil()))
+		client := WrapClient(mc)
+		err := client.Do(ctx, client.B().Get().Key("non_existent_key").Build()).Error()
+		assert.True(rueidis.IsRedisNil(err))
+
+		spans := mt.FinishedSpans()
+		require.Len(t, spans, 1)
+		assert.Nil(spans[0].Tag(ext.Error))
+	})
+
+	t.Run("pipeline", func(t *testing.T) {
+		ctx := context.Background()
+		assert := assert.New(t)
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		wantErr := errors.New("connection refused")
+		mc := newMockClient(t)
+		mc.EXPECT().DoMulti(gomock.Any(), gomock.Any(), gomock.Any()).Return([]rueidis.RedisResult{
+			mock.Result(mock.RedisNil()),
+			mock.ErrorResult(wantErr),
+		})
+		client := WrapClient(mc)
+		client.DoMulti(ctx, client.B().Get().Key("a").Build(), client.B().Get().Key("b").Build())
+
+		spans := mt.FinishedSpans()
+		require.Len(t, spans, 1)
+		assert.Equal(wantErr, spans[0].Tag(ext.Error))
+	})
+}
+
+func TestBinaryValue(t *testing.T) {
+	assert := assert.New(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	mc := newMockClient(t)
+	mc.EXPECT().Do(gomock.Any(), gomock.Any()).Return(mock.Result(mock.RedisString("OK")))
+	mc.EXPECT().DoMulti(gomock.Any(), gomock.Any(), gomock.Any()).Return([]rueidis.RedisResult{
+		mock.Result(mock.RedisString("OK")),
+		mock.Result(mock.RedisString("\x00\xff")),
+	})
+	ctx := context.Background()
+	client := WrapClient(mc)
+	client.Do(ctx, client.B().Set().Key("key\xc3").Value("\x00\xff\xfe").Build())
+	client.DoMulti(ctx,
+		client.B().Set().Key("key").Value("\x00\xff").Build(),
+		client.B().Get().Key("key").Build(),
+	)
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 2)
+	for _, span := range spans {
+		assert.True(utf8.ValidString(span.Tag("redis.raw_command").(string)))
+	}
+	assert.Equal("SET key\uFFFD \x00\uFFFD", spans[0].Tag("redis.raw_command"))
+	assert.Equal("SET key \x00\uFFFD\nGET key", spans[1].Tag("redis.raw_command"))
+}
+
+func TestSkipRaw(t *testing.T) {
+	runCmd := func(t *testing.T, opts ...ClientOption) mocktracer.Span {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		mc := newMockClient(t)
+		mc.EXPECT().Do(gomock.Any(), gomock.Any()).Return(mock.Result(mock.RedisString("OK")))
+		client := WrapClient(mc, opts...)
+		client.Do(context.Background(), client.B().Set().Key("test_key").Value("test_value").Build())
+
+		spans := mt.FinishedSpans()
+		require.Len(t, spans, 1)
+		return spans[0]
+	}
+
+	t.Run("true", func(t *testing.T) {
+		span := runCmd(t, WithSkipRawCommand(true))
+		_, ok := span.Tags()["redis.raw_command"]
+		assert.False(t, ok)
+	})
+
+	t.Run("default", func(t *testing.T) {
+		span := runCmd(t)
+		assert.Equal(t, "SET test_key test_value", span.Tag("redis.raw_command"))
+	})
+
+	t.Run("false", func(t *testing.T) {
+		span := runCmd(t, WithSkipRawCommand(false))
+		assert.Equal(t, "SET test_key test_value", span.Tag("redis.raw_command"))
+	})
+}
+
+func TestActiveSpan(t *testing.T) {
+	ctx := context.Background()
+	assert := assert.New(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	// the span must be the active one in the context handed over to rueidis,
+	// so that the profiler can correlate it with the goroutine running the command.
+	var active tracer.Span
+	mc := newMockClient(t)
+	mc.EXPECT().Do(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, _ rueidis.Completed) rueidis.RedisResult {
+		active, _ = tracer.SpanFromContext(ctx)
+		return mock.Result(mock.RedisString("OK"))
+	})
+	client := WrapClient(mc)
+
+	root, ctx := tracer.StartSpanFromContext(ctx, "parent.request")
+	client.Do(ctx, client.B().Ping().Build())
+	root.Finish()
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 2)
+	span := spans[0]
+	assert.Equal("redis.command", span.OperationName())
+	require.NotNil(t, active)
+	assert.Equal(span.SpanID(), active.Context().SpanID())
+	assert.Equal(root.Context().SpanID(), span.ParentID())
+}
+
+func TestAnalyticsSettings(t *testing.T) {
+	assertRate := func(t *testing.T, mt mocktracer.Tracer, rate interface{}, opts ...ClientOption) {
+		mc := newMockClient(t)
+		mc.EXPECT().Do(gomock.Any(), gomock.Any()).Return(mock.Result(mock.RedisString("OK")))
+		mc.EXPECT().DoMulti(gomock.Any(), gomock.Any()).Return([]rueidis.RedisResult{mock.Result(mock.RedisInt64(1))})
+		client := WrapClient(mc, opts...)
+		client.Do(context.Background(), client.B().Set().Key("test_key").Value("test_value").Build())
+		client.DoMulti(context.Background(), client.B().Expire().Key("pipeline_counter").Seconds(3600).Build())
+
+		spans := mt.FinishedSpans()
+		assert.Len(t, spans, 2)
+		for _, s := range spans {
+			assert.Equal(t, rate, s.Tag(ext.EventSampleRate))
+		}
+	}
+
+	t.Run("defaults", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		assertRate(t, mt, nil)
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		assertRate(t, mt, 1.0, WithAnalytics(true))
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		assertRate(t, mt, nil, WithAnalytics(false))
+	})
+
+	t.Run("override", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		assertRate(t, mt, 0.23, WithAnalyticsRate(0.23))
+	})
+
+	t.Run("zero", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		assertRate(t, mt, 0.0, WithAnalyticsRate(0.0))
+	})
+}
+
+func TestNamingSchema(t *testing.T) {
+	genSpans := namingschematest.GenSpansFn(func(t *testing.T, serviceOverride string) []mocktracer.Span {
+		var opts []ClientOption
+		if serviceOverride != "" {
+			opts = append(opts, WithServiceName(serviceOverride))
+		}
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		mc := newMockClient(t)
+		mc.EXPECT().Do(gomock.Any(), gomock.Any()).Return(mock.Result(mock.RedisString("OK")))
+		client := WrapClient(mc, opts...)
+		client.Do(context.Background(), client.B().Set().Key("test_key").Value("test_value").Build())
+
+		return mt.FinishedSpans()
+	})
+	assertOp := func(t *testing.T, spans []mocktracer.Span) {
+		require.Len(t, spans, 1)
+		assert.Equal(t, "redis.command", spans[0].OperationName())
+	}
+	// unlike the other redis integrations, the service name defaults to DD_SERVICE
+	// with every naming schema version
+	wantServiceName := namingschematest.ServiceNameAssertions{
+		WithDefaults:             []string{"redis.client"},
+		WithDDService:            []string{namingschematest.TestDDService},
+		WithDDServiceAndOverride: []string{namingschematest.TestServiceOverride},
+	}
+	t.Run("ServiceName", namingschematest.NewServiceNameTest(genSpans, wantServiceName))
+	t.Run("SpanName", namingschematest.NewSpanNameTest(genSpans, assertOp, assertOp))
+}
+
+func TestDDServiceEnv(t *testing.T) {
+	t.Setenv("DD_SERVICE", "my-service")
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	mc := newMockClient(t)
+	mc.EXPECT().Do(gomock.Any(), gomock.Any()).Return(mock.Result(mock.RedisString("OK"))).Times(2)
+	ctx := context.Background()
+	client := WrapClient(mc)
+	client.Do(ctx, client.B().Get().Key("key").Build())
+	client = WrapClient(mc, WithServiceName("my-redis"))
+	client.Do(ctx, client.B().Get().Key("key").Build())
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 2)
+	assert.Equal(t, "my-service", spans[0].Tag(ext.ServiceName))
+	assert.Equal(t, "my-redis", spans[1].Tag(ext.ServiceName))
+}
+
+func TestCallerTag(t *testing.T) {
+	run := func(t *testing.T, opts ...ClientOption) mocktracer.Span {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		mc := newMockClient(t)
+		mc.EXPECT().Do(gomock.Any(), gomock.Any()).Return(mock.Result(mock.RedisString("OK")))
+		client := WrapClient(mc, opts...)
+		client.Do(context.Background(), client.B().Ping().Build())
+
+		spans := mt.FinishedSpans()
+		require.Len(t, spans, 1)
+		return spans[0]
+	}
+
+	t.Run("default", func(t *testing.T) {
+		span := run(t)
+		assert.NotContains(t, span.Tags(), "redis.caller")
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		span := run(t, WithCallerTag())
+		// the closure issuing the command is TestCallerTag.func1
+		assert.Equal(t, "gopkg.in/DataDog/dd-trace-go.v1/contrib/redis/rueidis.TestCallerTag.func1", span.Tag("redis.caller"))
+	})
+}
+
+func TestShardService(t *testing.T) {
+	assert := assert.New(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	ctrl := gomock.NewController(t)
+	nodes := map[string]rueidis.Client{}
+	for _, addr := range []string{"10.0.0.1:6379", "10.0.0.2:6379"} {
+		node := mock.NewClient(ctrl)
+		node.EXPECT().Nodes().Return(map[string]rueidis.Client{addr: node}).AnyTimes()
+		node.EXPECT().Do(gomock.Any(), gomock.Any()).Return(mock.Result(mock.RedisString("PONG")))
+		nodes[addr] = node
+	}
+	mc := mock.NewClient(ctrl)
+	mc.EXPECT().Nodes().DoAndReturn(func() map[string]rueidis.Client {
+		m := make(map[string]rueidis.Client, len(nodes))
+		for addr, node := range nodes {
+			m[addr] = node
+		}
+		return m
+	}).AnyTimes()
+	mc.EXPECT().Do(gomock.Any(), gomock.Any()).Return(mock.Result(mock.RedisString("PONG")))
+
+	client := WrapClient(mc, WithServiceName("my-redis"), WithShardService(func(node string) string {
+		return map[string]string{
+			"10.0.0.1:6379": "redis-shard-1",
+			"10.0.0.2:6379": "redis-shard-2",
+		}[node]
+	}))
+	ctx := context.Background()
+	for _, node := range client.Nodes() {
+		node.Do(ctx, node.B().Ping().Build())
+	}
+	// the node serving a command sent through the cluster client is unknown
+	client.Do(ctx, client.B().Ping().Build())
+
+	services := []string{}
+	for _, s := range mt.FinishedSpans() {
+		services = append(services, s.Tag(ext.ServiceName).(string))
+	}
+	assert.ElementsMatch([]string{"redis-shard-1", "redis-shard-2", "my-redis"}, services)
+}
+
+func TestTimeoutTag(t *testing.T) {
+	assert := assert.New(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	mc := newMockClient(t)
+	mc.EXPECT().Do(gomock.Any(), gomock.Any()).Return(mock.Result(mock.RedisString("OK"))).Times(3)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client := WrapClient(mc)
+	client.Do(ctx, client.B().Get().Key("key").Build())
+	client = WrapClient(mc, WithTimeoutTag())
+	client.Do(ctx, client.B().Get().Key("key").Build())
+	client.Do(context.Background(), client.B().Get().Key("key").Build())
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 3)
+	assert.NotContains(spans[0].Tags(), TagTimeout)
+	timeout, ok := spans[1].Tag(TagTimeout).(int64)
+	require.True(t, ok)
+	assert.InDelta(5000, timeout, 1000)
+	assert.NotContains(spans[2].Tags(), TagTimeout)
+}
+
+func TestKeyPrefixSampling(t *testing.T) {
+	assert := assert.New(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	mc := newMockClient(t)
+	mc.EXPECT().Do(gomock.Any(), gomock.Any()).Return(mock.Result(mock.RedisString("OK"))).Times(4)
+	client := WrapClient(mc, WithAnalyticsRate(0.5), WithKeyPrefixSampling(map[string]float64{
+		"admin:":       1,
+		"cache:":       0.1,
+		"cache:users:": 0.2,
+	}))
+	ctx := context.Background()
+	client.Do(ctx, client.B().Get().Key("admin:settings").Build())
+	client.Do(ctx, client.B().Get().Key("cache:page").Build())
+	client.Do(ctx, client.B().Get().Key("cache:users:1").Build())
+	client.Do(ctx, client.B().Get().Key("session:1").Build())
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 4)
+	assert.Equal(1.0, spans[0].Tag(ext.EventSampleRate))
+	assert.Equal(0.1, spans[1].Tag(ext.EventSampleRate))
+	assert.Equal(0.2, spans[2].Tag(ext.EventSampleRate))
+	assert.Equal(0.5, spans[3].Tag(ext.EventSampleRate))
+}
+
+func TestTransactionAbortedTag(t *testing.T) {
+	assert := assert.New(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	mc := newMockClient(t)
+	mc.EXPECT().Do(gomock.Any(), mock.Match("EXEC")).Return(mock.Result(mock.RedisNil()))
+	mc.EXPECT().Do(gomock.Any(), mock.Match("GET", "key")).Return(mock.Result(mock.RedisString("value")))
+	mc.EXPECT().DoMulti(gomock.Any(), gomock.Any()).Return([]rueidis.RedisResult{
+		mock.Result(mock.RedisString("OK")),
+		mock.Result(mock.RedisString("QUEUED")),
+		mock.Result(mock.RedisArray(mock.RedisString("OK"))),
+	})
+	mc.EXPECT().DoMulti(gomock.Any(), gomock.Any()).Return([]rueidis.RedisResult{
+		mock.Result(mock.RedisString("OK")),
+		mock.Result(mock.RedisString("QUEUED")),
+		mock.Result(mock.RedisNil()),
+	})
+	client := WrapClient(mc, WithTransactionAbortedTag())
+	ctx := context.Background()
+	client.Do(ctx, client.B().Exec().Build())
+	client.Do(ctx, client.B().Get().Key("key").Build())
+	for i := 0; i < 2; i++ {
+		client.DoMulti(ctx,
+			client.B().Multi().Build(),
+			client.B().Set().Key("key").Value("value").Build(),
+			client.B().Exec().Build(),
+		)
+	}
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 4)
+	assert.Equal(true, spans[0].Tag(TagTransactionAborted))
+	assert.Nil(spans[0].Tag(ext.Error))
+	assert.NotContains(spans[1].Tags(), TagTransactionAborted)
+	assert.Equal(false, spans[2].Tag(TagTransactionAborted))
+	assert.Equal(true, spans[3].Tag(TagTransactionAborted))
+}
+
+func TestResultTypeTag(t *testing.T) {
+	assert := assert.New(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	mc := newMockClient(t)
+	mc.EXPECT().Do(gomock.Any(), mock.Match("INCR", "counter")).Return(mock.Result(mock.RedisInt64(1))).Times(2)
+	mc.EXPECT().Do(gomock.Any(), mock.Match("GET", "key")).Return(mock.Result(mock.RedisNil()))
+	mc.EXPECT().DoCache(gomock.Any(), mock.Match("SMEMBERS", "set"), time.Minute).Return(mock.Result(mock.RedisArray(mock.RedisString("member"))))
+	mc.EXPECT().DoMulti(gomock.Any(), gomock.Any()).Return([]rueidis.RedisResult{mock.Result(mock.RedisInt64(1))})
+	ctx := context.Background()
+	client := WrapClient(mc)
+	client.Do(ctx, client.B().Incr().Key("counter").Build())
+	client = WrapClient(mc, WithResultTypeTag())
+	client.Do(ctx, client.B().Incr().Key("counter").Build())
+	client.Do(ctx, client.B().Get().Key("key").Build())
+	client.DoCache(ctx, client.B().Smembers().Key("set").Cache(), time.Minute)
+	client.DoMulti(ctx, client.B().Incr().Key("counter").Build())
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 5)
+	assert.NotContains(spans[0].Tags(), TagResultType)
+	assert.Equal("integer", spans[1].Tag(TagResultType))
+	assert.Equal("null", spans[2].Tag(TagResultType))
+	assert.Equal("array", spans[3].Tag(TagResultType))
+	assert.NotContains(spans[4].Tags(), TagResultType)
+
+	for _, tc := range []struct {
+		resp     rueidis.RedisResult
+		expected string
+	}{
+		{mock.Result(mock.RedisString("value")), "string"},
+		{mock.Result(mock.RedisFloat64(1.5)), "double"},
+		{mock.Result(mock.RedisBool(true)), "boolean"},
+		{mock.Result(mock.RedisMap(map[string]rueidis.RedisMessage{"k": mock.RedisString("v")})), "map"},
+		{mock.Result(mock.RedisError("ERR syntax error")), "error"},
+		{mock.ErrorResult(errors.New("timeout")), "error"},
+	} {
+		typ, ok := resultType(tc.resp)
+		assert.True(ok)
+		assert.Equal(tc.expected, typ)
+	}
+}
+
+func TestRequestSizeTag(t *testing.T) {
+	assert := assert.New(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	mc := newMockClient(t)
+	mc.EXPECT().Do(gomock.Any(), gomock.Any()).Return(mock.Result(mock.RedisString("OK"))).Times(2)
+	mc.EXPECT().DoMulti(gomock.Any(), gomock.Any(), gomock.Any()).Return([]rueidis.RedisResult{
+		mock.Result(mock.RedisString("OK")),
+		mock.Result(mock.RedisString("value")),
+	})
+	ctx := context.Background()
+	client := WrapClient(mc)
+	client.Do(ctx, client.B().Set().Key("key").Value("value").Build())
+	client = WrapClient(mc, WithRequestSizeTag())
+	client.Do(ctx, client.B().Set().Key("key").Value("value").Build())
+	client.DoMulti(ctx,
+		client.B().Set().Key("key").Value("value").Build(),
+		client.B().Get().Key("key").Build(),
+	)
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 3)
+	assert.NotContains(spans[0].Tags(), "redis.request_bytes")
+	assert.Equal(11, spans[1].Tag("redis.request_bytes")) // "SET" + "key" + "value"
+	assert.Equal(17, spans[2].Tag("redis.request_bytes")) // "SET" + "key" + "value" + "GET" + "key"
+}
+
+func TestMaxRawCommands(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	mc := newMockClient(t)
+	mc.EXPECT().DoMulti(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, multi ...rueidis.Completed) []rueidis.RedisResult {
+		resps := make([]rueidis.RedisResult, len(multi))
+		for i := range resps {
+			resps[i] = mock.Result(mock.RedisString("value"))
+		}
+		return resps
+	})
+	client := WrapClient(mc, WithMaxRawCommands(3))
+	cmds := make(rueidis.Commands, 10)
+	for i := range cmds {
+		cmds[i] = client.B().Get().Key(fmt.Sprintf("key%d", i)).Build()
+	}
+	client.DoMulti(context.Background(), cmds...)
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "GET key0\nGET key1\nGET key2\n+7 more", spans[0].Tag(TagRawCommand))
+	assert.Equal(t, 10, spans[0].Tag(TagPipelineSize))
+}
+
+func TestFunctionResource(t *testing.T) {
+	assert := assert.New(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	mc := newMockClient(t)
+	mc.EXPECT().Do(gomock.Any(), gomock.Any()).Return(mock.Result(mock.RedisString("OK"))).Times(3)
+	ctx := context.Background()
+	client := WrapClient(mc)
+	client.Do(ctx, client.B().Fcall().Function("myfunc").Numkeys(1).Key("key").Build())
+	client = WrapClient(mc, WithFunctionResource())
+	client.Do(ctx, client.B().Fcall().Function("myfunc").Numkeys(1).Key("key").Build())
+	client.Do(ctx, client.B().FcallRo().Function("myfunc_ro").Numkeys(0).Build())
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 3)
+	assert.Equal("FCALL", spans[0].Tag(ext.ResourceName))
+	assert.NotContains(spans[0].Tags(), TagFunction)
+	assert.Equal("FCALL myfunc", spans[1].Tag(ext.ResourceName))
+	assert.Equal("myfunc", spans[1].Tag(TagFunction))
+	assert.Equal("FCALL_RO myfunc_ro", spans[2].Tag(ext.ResourceName))
+	assert.Equal("myfunc_ro", spans[2].Tag(TagFunction))
+}
+
+func TestExcludeResources(t *testing.T) {
+	assert := assert.New(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	mc := newMockClient(t)
+	mc.EXPECT().Do(gomock.Any(), gomock.Any()).Return(mock.Result(mock.RedisString("OK"))).Times(3)
+	var stats HookStats
+	client := WrapClient(mc,
+		WithResourceFromFirstKey(func(key string) string { return key }),
+		WithExcludeResources([]string{"PING", "GET health"}),
+		WithHookStats(&stats),
+	)
+	ctx := context.Background()
+	client.Do(ctx, client.B().Ping().Build())
+	client.Do(ctx, client.B().Get().Key("health").Build())
+	client.Do(ctx, client.B().Get().Key("key").Build())
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 1)
+	assert.Equal("GET key", spans[0].Tag(ext.ResourceName))
+	assert.Equal(int64(2), stats.SpansSkipped())
+}
+
+func TestMeasuredResources(t *testing.T) {
+	assert := assert.New(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	mc := newMockClient(t)
+	mc.EXPECT().Do(gomock.Any(), gomock.Any()).Return(mock.Result(mock.RedisString("OK"))).Times(2)
+	client := WrapClient(mc, WithMeasuredResources([]string{"GET"}))
+	ctx := context.Background()
+	client.Do(ctx, client.B().Get().Key("key").Build())
+	client.Do(ctx, client.B().Set().Key("key").Value("value").Build())
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 2)
+	assert.Equal(1, spans[0].Tag("_dd.measured"))
+	assert.NotContains(spans[1].Tags(), "_dd.measured")
+}
+
+func TestNilAsErrorForVerbs(t *testing.T) {
+	assert := assert.New(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	mc := newMockClient(t)
+	mc.EXPECT().Do(gomock.Any(), mock.Match("GET", "key")).Return(mock.Result(mock.RedisNil())).Times(2)
+	ctx := context.Background()
+	client := WrapClient(mc, WithNilAsErrorForVerbs([]string{"get"}))
+	client.Do(ctx, client.B().Get().Key("key").Build())
+	client = WrapClient(mc)
+	client.Do(ctx, client.B().Get().Key("key").Build())
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 2)
+	assert.True(rueidis.IsRedisNil(spans[0].Tag(ext.Error).(error)))
+	assert.Nil(spans[1].Tag(ext.Error))
+}
+
+func TestKeysCountTag(t *testing.T) {
+	assert := assert.New(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	mc := newMockClient(t)
+	mc.EXPECT().Do(gomock.Any(), gomock.Any()).Return(mock.Result(mock.RedisString("OK"))).Times(4)
+	client := WrapClient(mc, WithKeysCountTag())
+	ctx := context.Background()
+	client.Do(ctx, client.B().Mget().Key("k1", "k2", "k3", "k4", "k5").Build())
+	client.Do(ctx, client.B().Mset().KeyValue().KeyValue("k1", "v1").KeyValue("k2", "v2").Build())
+	client.Do(ctx, client.B().Blpop().Key("k1", "k2").Timeout(1).Build())
+	client.Do(ctx, client.B().Get().Key("k1").Build())
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 4)
+	assert.Equal(5, spans[0].Tag(TagKeysCount))
+	assert.Equal(2, spans[1].Tag(TagKeysCount))
+	assert.Equal(2, spans[2].Tag(TagKeysCount))
+	assert.NotContains(spans[3].Tags(), TagKeysCount)
+}
+
+func TestFinishHook(t *testing.T) {
+	assert := assert.New(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	mc := newMockClient(t)
+	mc.EXPECT().Do(gomock.Any(), mock.Match("GET", "key")).Return(mock.Result(mock.RedisString("value")))
+	mc.EXPECT().Do(gomock.Any(), mock.Match("SET", "key", "value")).Return(mock.ErrorResult(errors.New("oops")))
+	mc.EXPECT().Do(gomock.Any(), mock.Match("PING")).Return(mock.Result(mock.RedisString("PONG")))
+	var (
+		verbs []string
+		errs  []error
+	)
+	client := WrapClient(mc,
+		WithIgnoredCommands("PING"),
+		WithFinishHook(func(verb string, dur time.Duration, err error) {
+			verbs = append(verbs, verb)
+			errs = append(errs, err)
+		}),
+	)
+	ctx := context.Background()
+	client.Do(ctx, client.B().Get().Key("key").Build())
+	client.Do(ctx, client.B().Set().Key("key").Value("value").Build())
+	client.Do(ctx, client.B().Ping().Build())
+
+	assert.Equal([]string{"GET", "SET"}, verbs)
+	require.Len(t, errs, 2)
+	assert.NoError(errs[0])
+	assert.EqualError(errs[1], "oops")
+}
+
+func TestRawCommandSampleRate(t *testing.T) {
+	const n = 1000
+	for _, tc := range []struct {
+		rate     float64
+		min, max int
+	}{
+		{rate: 0, min: 0, max: 0},
+		{rate: 0.25, min: 150, max: 350},
+		{rate: 1, min: n, max: n},
+	} {
+		tc := tc
+		t.Run(fmt.Sprint(tc.rate), func(t *testing.T) {
+			mt := mocktracer.Start()
+			defer mt.Stop()
+
+			mc := newMockClient(t)
+			mc.EXPECT().Do(gomock.Any(), gomock.Any()).Return(mock.Result(mock.RedisString("value"))).Times(n)
+			client := WrapClient(mc, WithRawCommandSampleRate(tc.rate))
+			ctx := context.Background()
+			for i := 0; i < n; i++ {
+				client.Do(ctx, client.B().Get().Key("key").Build())
+			}
+
+			var sampled int
+			for _, span := range mt.FinishedSpans() {
+				if raw, ok := span.Tags()[TagRawCommand]; ok {
+					assert.Equal(t, "GET key", raw)
+					sampled++
+				}
+			}
+			assert.GreaterOrEqual(t, sampled, tc.min)
+			assert.LessOrEqual(t, sampled, tc.max)
+		})
+	}
+}
+
+func TestHookOverheadTag(t *testing.T) {
+	assert := assert.New(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	mc := newMockClient(t)
+	mc.EXPECT().Do(gomock.Any(), gomock.Any()).Return(mock.Result(mock.RedisString("value"))).Times(2)
+	ctx := context.Background()
+	client := WrapClient(mc, WithHookOverheadTag())
+	client.Do(ctx, client.B().Get().Key("key").Build())
+	client = WrapClient(mc)
+	client.Do(ctx, client.B().Get().Key("key").Build())
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 2)
+	overhead, ok := spans[0].Tag(TagHookOverhead).(int64)
+	require.True(t, ok)
+	assert.GreaterOrEqual(overhead, int64(0))
+	assert.NotContains(spans[1].Tags(), TagHookOverhead)
+}
+
+func TestCommandsPerFlushTag(t *testing.T) {
+	assert := assert.New(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	mc := newMockClient(t)
+	mc.EXPECT().Do(gomock.Any(), gomock.Any()).Return(mock.Result(mock.RedisString("value")))
+	mc.EXPECT().DoMulti(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return([]rueidis.RedisResult{
+		mock.Result(mock.RedisString("OK")),
+		mock.Result(mock.RedisString("value")),
+		mock.Result(mock.RedisInt64(1)),
+	})
+	client := WrapClient(mc, WithCommandsPerFlushTag())
+	ctx := context.Background()
+	client.Do(ctx, client.B().Get().Key("key").Build())
+	cmds := rueidis.Commands{
+		client.B().Set().Key("key").Value("value").Build(),
+		client.B().Get().Key("key").Build(),
+		client.B().Del().Key("key").Build(),
+	}
+	n := len(cmds)
+	client.DoMulti(ctx, cmds...)
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 2)
+	assert.NotContains(spans[0].Tags(), TagCommandsPerFlush)
+	assert.Equal(n, spans[1].Tag(TagCommandsPerFlush))
+}
+
+func TestServerLoadingTag(t *testing.T) {
+	assert := assert.New(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	const loading = "LOADING Redis is loading the dataset in memory"
+	mc := newMockClient(t)
+	mc.EXPECT().Do(gomock.Any(), mock.Match("GET", "key")).Return(mock.Result(mock.RedisError(loading))).Times(2)
+	mc.EXPECT().Do(gomock.Any(), mock.Match("SET", "key", "value")).Return(mock.Result(mock.RedisError("ERR oops")))
+	var stats HookStats
+	ctx := context.Background()
+	client := WrapClient(mc, WithServerLoadingTag(), WithHookStats(&stats))
+	err := client.Do(ctx, client.B().Get().Key("key").Build()).Error()
+	assert.EqualError(err, loading)
+	client.Do(ctx, client.B().Set().Key("key").Value("value").Build())
+	client = WrapClient(mc)
+	client.Do(ctx, client.B().Get().Key("key").Build())
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 3)
+	assert.Equal(true, spans[0].Tag(TagServerLoading))
+	assert.Nil(spans[0].Tag(ext.Error))
+	assert.NotContains(spans[1].Tags(), TagServerLoading)
+	assert.NotNil(spans[1].Tag(ext.Error))
+	assert.Equal(int64(1), stats.SpansErrored())
+	assert.NotContains(spans[2].Tags(), TagServerLoading)
+	assert.NotNil(spans[2].Tag(ext.Error))
+}
+
+func TestSpanContextExtractor(t *testing.T) {
+	assert := assert.New(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	remote, err := tracer.Extract(tracer.TextMapCarrier{
+		tracer.DefaultTraceIDHeader:  "1234",
+		tracer.DefaultParentIDHeader: "5678",
+	})
+	require.NoError(t, err)
+	type remoteKey struct{}
+
+	mc := newMockClient(t)
+	mc.EXPECT().Do(gomock.Any(), gomock.Any()).Return(mock.Result(mock.RedisString("value"))).Times(2)
+	client := WrapClient(mc,
+		WithOnlyWithinTrace(),
+		WithSpanContextExtractor(func(ctx context.Context) ddtrace.SpanContext {
+			sc, _ := ctx.Value(remoteKey{}).(ddtrace.SpanContext)
+			return sc
+		}),
+	)
+	local, ctx := tracer.StartSpanFromContext(context.Background(), "local")
+	client.Do(context.WithValue(ctx, remoteKey{}, remote), client.B().Get().Key("key").Build())
+	client.Do(ctx, client.B().Get().Key("key").Build())
+	local.Finish()
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 3)
+	assert.Equal(uint64(1234), spans[0].TraceID())
+	assert.Equal(uint64(5678), spans[0].ParentID())
+	assert.Equal(local.Context().TraceID(), spans[1].TraceID())
+	assert.Equal(local.Context().SpanID(), spans[1].ParentID())
+}
+
+func TestIdempotencyTag(t *testing.T) {
+	assert := assert.New(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	mc := newMockClient(t)
+	mc.EXPECT().Do(gomock.Any(), gomock.Any()).Return(mock.Result(mock.RedisString("OK"))).Times(4)
+	client := WrapClient(mc, WithIdempotencyTag(map[string]bool{"set": false}))
+	ctx := context.Background()
+	client.Do(ctx, client.B().Get().Key("key").Build())
+	client.Do(ctx, client.B().Incr().Key("key").Build())
+	client.Do(ctx, client.B().Set().Key("key").Value("value").Build())
+	client.Do(ctx, client.B().Echo().Message("hello").Build())
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 4)
+	assert.Equal(true, spans[0].Tag(TagIdempotent))
+	assert.Equal(false, spans[1].Tag(TagIdempotent))
+	assert.Equal(false, spans[2].Tag(TagIdempotent))
+	assert.NotContains(spans[3].Tags(), TagIdempotent)
+}
+
+func TestBaselineRTT(t *testing.T) {
+	assert := assert.New(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	clock := &fakeClock{t: time.Now()}
+	mc := newMockClient(t)
+	mc.EXPECT().Do(gomock.Any(), gomock.Any()).DoAndReturn(func(context.Context, rueidis.Completed) rueidis.RedisResult {
+		clock.advance(20 * time.Millisecond)
+		return mock.Result(mock.RedisString("value"))
+	}).Times(2)
+	ctx := context.Background()
+	client := WrapClient(mc, withClock(clock), WithBaselineRTT(func(addr string) time.Duration {
+		assert.Equal("127.0.0.1:6379", addr)
+		return 5 * time.Millisecond
+	}))
+	client.Do(ctx, client.B().Get().Key("key").Build())
+	client = WrapClient(mc, withClock(clock), WithBaselineRTT(func(string) time.Duration { return time.Hour }))
+	client.Do(ctx, client.B().Get().Key("key").Build())
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 2)
+	assert.Equal(20*time.Millisecond, spans[0].FinishTime().Sub(spans[0].StartTime()))
+	assert.Equal(15.0, spans[0].Tag(TagServerProcessing))
+	assert.Equal(0.0, spans[1].Tag(TagServerProcessing))
+}
+
+func TestAllocTracking(t *testing.T) {
+	assert := assert.New(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	var sink []byte
+	mc := newMockClient(t)
+	mc.EXPECT().Do(gomock.Any(), gomock.Any()).DoAndReturn(func(context.Context, rueidis.Completed) rueidis.RedisResult {
+		sink = make([]byte, 1<<20)
+		return mock.Result(mock.RedisString("value"))
+	}).Times(2)
+	ctx := context.Background()
+	client := WrapClient(mc, WithAllocTracking(1))
+	client.Do(ctx, client.B().Get().Key("key").Build())
+	client = WrapClient(mc)
+	client.Do(ctx, client.B().Get().Key("key").Build())
+	assert.Len(sink, 1<<20)
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 2)
+	allocs, ok := spans[0].Tag(TagAllocBytes).(int64)
+	require.True(t, ok)
+	assert.GreaterOrEqual(allocs, int64(1<<20))
+	assert.NotContains(spans[1].Tags(), TagAllocBytes)
+}
+
+func TestAllocDelta(t *testing.T) {
+	for _, tt := range []struct {
+		start, end uint64
+		want       int64
+		ok         bool
+	}{
+		{start: 100, end: 150, want: 50, ok: true},
+		{start: 100, end: 100, want: 0, ok: true},
+		{start: 0, end: 150},
+		{start: 100, end: 0},
+		{start: 150, end: 100},
+	} {
+		n, ok := allocDelta(tt.start, tt.end)
+		assert.Equal(t, tt.ok, ok, "%d to %d", tt.start, tt.end)
+		assert.Equal(t, tt.want, n, "%d to %d", tt.start, tt.end)
+	}
+}
+
+func TestReplyTagExtractor(t *testing.T) {
+	assert := assert.New(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	mc := newMockClient(t)
+	mc.EXPECT().Do(gomock.Any(), mock.Match("GET", "doc")).Return(mock.Result(mock.RedisString(`{"version":3}`)))
+	mc.EXPECT().Do(gomock.Any(), mock.Match("GET", "missing")).Return(mock.Result(mock.RedisNil()))
+	mc.EXPECT().Do(gomock.Any(), mock.Match("GET", "panic")).Return(mock.Result(mock.RedisString("value")))
+	client := WrapClient(mc, WithReplyTagExtractor(func(verb string, reply rueidis.RedisResult) []ddtrace.StartSpanOption {
+		assert.Equal("GET", verb)
+		if s, _ := reply.ToString(); s == "value" {
+			panic("unexpected reply")
+		}
+		var doc struct {
+			Version int `json:"version"`
+		}
+		if err := reply.DecodeJSON(&doc); err != nil {
+			return nil
+		}
+		return []ddtrace.StartSpanOption{tracer.Tag("doc.version", doc.Version)}
+	}))
+	ctx := context.Background()
+	client.Do(ctx, client.B().Get().Key("doc").Build())
+	client.Do(ctx, client.B().Get().Key("missing").Build())
+	assert.NotPanics(func() {
+		client.Do(ctx, client.B().Get().Key("panic").Build())
+	})
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 3)
+	assert.Equal(3, spans[0].Tag("doc.version"))
+	assert.NotContains(spans[1].Tags(), "doc.version")
+	assert.NotContains(spans[2].Tags(), "doc.version")
+}
+
+func TestConsistencyTag(t *testing.T) {
+	assert := assert.New(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	mc := newMockClient(t)
+	mc.EXPECT().Do(gomock.Any(), gomock.Any()).Return(mock.Result(mock.RedisInt64(1))).Times(3)
+	mc.EXPECT().DoMulti(gomock.Any(), gomock.Any(), gomock.Any()).Return([]rueidis.RedisResult{
+		mock.Result(mock.RedisString("OK")),
+		mock.Result(mock.RedisInt64(1)),
+	})
+	client := WrapClient(mc, WithConsistencyTag("eventual"))
+	ctx := context.Background()
+	client.Do(ctx, client.B().Get().Key("key").Build())
+	client.Do(ctx, client.B().Wait().Numreplicas(1).Timeout(100).Build())
+	client.DoMulti(ctx,
+		client.B().Set().Key("key").Value("value").Build(),
+		client.B().Wait().Numreplicas(1).Timeout(100).Build(),
+	)
+	client = WrapClient(mc)
+	client.Do(ctx, client.B().Wait().Numreplicas(1).Timeout(100).Build())
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 4)
+	assert.Equal("eventual", spans[0].Tag(TagConsistency))
+	assert.Equal("strong", spans[1].Tag(TagConsistency))
+	assert.Equal("strong", spans[2].Tag(TagConsistency))
+	assert.NotContains(spans[3].Tags(), TagConsistency)
+}
+
+func TestOTelConventions(t *testing.T) {
+	assert := assert.New(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	mc := newMockClient(t)
+	mc.EXPECT().Do(gomock.Any(), mock.Match("SET", "key", "value")).Return(mock.ErrorResult(errors.New("oops"))).Times(2)
+	mc.EXPECT().Do(gomock.Any(), mock.Match("GET", "key")).Return(mock.Result(mock.RedisString("value")))
+	ctx := context.Background()
+	client := WrapClient(mc, WithOTelConventions())
+	client.Do(ctx, client.B().Set().Key("key").Value("value").Build())
+	client.Do(ctx, client.B().Get().Key("key").Build())
+	client = WrapClient(mc)
+	client.Do(ctx, client.B().Set().Key("key").Value("value").Build())
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 3)
+	assert.Equal("ERROR", spans[0].Tag(TagOTelStatusCode))
+	assert.Equal("oops", spans[0].Tag(TagOTelStatusDescription))
+	for _, span := range spans[1:] {
+		assert.NotContains(span.Tags(), TagOTelStatusCode)
+		assert.NotContains(span.Tags(), TagOTelStatusDescription)
+	}
+}
+
+func TestErrorSink(t *testing.T) {
+	assert := assert.New(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	mc := newMockClient(t)
+	mc.EXPECT().Do(gomock.Any(), mock.Match("GET", "missing")).Return(mock.Result(mock.RedisNil()))
+	mc.EXPECT().Do(gomock.Any(), mock.Match("SET", "key", "value")).Return(mock.ErrorResult(errors.New("oops")))
+	mc.EXPECT().Do(gomock.Any(), mock.Match("GET", "key")).Return(mock.Result(mock.RedisString("value")))
+	var (
+		verbs []string
+		errs  []error
+	)
+	client := WrapClient(mc, WithErrorSink(func(verb string, err error) {
+		verbs = append(verbs, verb)
+		errs = append(errs, err)
+	}))
+	ctx := context.Background()
+	client.Do(ctx, client.B().Get().Key("missing").Build())
+	client.Do(ctx, client.B().Set().Key("key").Value("value").Build())
+	client.Do(ctx, client.B().Get().Key("key").Build())
+
+	assert.Equal([]string{"SET"}, verbs)
+	require.Len(t, errs, 1)
+	assert.EqualError(errs[0], "oops")
+}
+
+func TestSchedulerTags(t *testing.T) {
+	assert := assert.New(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	mc := newMockClient(t)
+	mc.EXPECT().Do(gomock.Any(), mock.Match("GET", "key")).Return(mock.Result(mock.RedisString("value"))).Times(2)
+	ctx := context.Background()
+	client := WrapClient(mc, WithSchedulerTags())
+	client.Do(ctx, client.B().Get().Key("key").Build())
+	client = WrapClient(mc)
+	client.Do(ctx, client.B().Get().Key("key").Build())
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 2)
+	id, ok := spans[0].Tag(TagGoroutineID).(uint64)
+	assert.True(ok)
+	assert.NotZero(id)
+	assert.Equal(runtime.GOMAXPROCS(0), spans[0].Tag(TagGOMAXPROCS))
+	assert.NotContains(spans[1].Tags(), TagGoroutineID)
+	assert.NotContains(spans[1].Tags(), TagGOMAXPROCS)
+}
+
+func TestGoroutineID(t *testing.T) {
+	id, ok := goroutineID()
+	require.True(t, ok)
+	ids := make(chan uint64)
+	go func() {
+		other, _ := goroutineID()
+		ids <- other
+	}()
+	assert.NotEqual(t, id, <-ids)
+}
+
+func TestCacheSizeTag(t *testing.T) {
+	assert := assert.New(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	mc := newMockClient(t)
+	mc.EXPECT().DoCache(gomock.Any(), mock.Match("GET", "key"), time.Minute).Return(mock.Result(mock.RedisString("value"))).Times(2)
+	mc.EXPECT().DoCache(gomock.Any(), mock.Match("HGETALL", "hash"), time.Minute).Return(mock.Result(mock.RedisMap(map[string]rueidis.RedisMessage{
+		"field": mock.RedisString("value"),
+		"count": mock.RedisInt64(42),
+	})))
+	mc.EXPECT().DoCache(gomock.Any(), mock.Match("GET", "broken"), time.Minute).Return(mock.ErrorResult(errors.New("oops")))
+	mc.EXPECT().DoMultiCache(gomock.Any(), gomock.Any()).Return([]rueidis.RedisResult{
+		mock.Result(mock.RedisString("value")),
+		mock.Result(mock.RedisArray(mock.RedisString("a"), mock.RedisString("bc"))),
+		mock.Result(mock.RedisNil()),
+		mock.ErrorResult(errors.New("oops")),
+	})
+	ctx := context.Background()
+	client := WrapClient(mc, WithCacheSizeTag())
+	client.DoCache(ctx, client.B().Get().Key("key").Cache(), time.Minute)
+	client.DoCache(ctx, client.B().Hgetall().Key("hash").Cache(), time.Minute)
+	client.DoCache(ctx, client.B().Get().Key("broken").Cache(), time.Minute)
+	client.DoMultiCache(ctx,
+		rueidis.CT(client.B().Get().Key("key").Cache(), time.Minute),
+		rueidis.CT(client.B().Smembers().Key("set").Cache(), time.Minute),
+		rueidis.CT(client.B().Get().Key("missing").Cache(), time.Minute),
+		rueidis.CT(client.B().Get().Key("broken").Cache(), time.Minute),
+	)
+	client = WrapClient(mc)
+	client.DoCache(ctx, client.B().Get().Key("key").Cache(), time.Minute)
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 5)
+	assert.Equal(len("value"), spans[0].Tag(TagCacheEntryBytes))
+	assert.Equal(len("field")+len("value")+len("count")+8, spans[1].Tag(TagCacheEntryBytes))
+	assert.NotContains(spans[2].Tags(), TagCacheEntryBytes)
+	assert.Equal(len("value")+len("a")+len("bc"), spans[3].Tag(TagCacheEntryBytes))
+	assert.NotContains(spans[4].Tags(), TagCacheEntryBytes)
+}
+
+func TestTransportTag(t *testing.T) {
+	for _, tt := range []struct {
+		addrs     []string
+		transport string
+	}{
+		{addrs: []string{"/var/run/redis.sock"}, transport: "unix"},
+		{addrs: []string{"unix:///var/run/redis.sock"}, transport: "unix"},
+		{addrs: []string{"127.0.0.1:6379"}, transport: "tcp"},
+		{addrs: []string{"redis.local"}, transport: "tcp"},
+		{addrs: []string{"/var/run/redis.sock", "127.0.0.1:6379"}, transport: "tcp"},
+	} {
+		t.Run(strings.Join(tt.addrs, ","), func(t *testing.T) {
+			mt := mocktracer.Start()
+			defer mt.Stop()
+
+			mc := mock.NewClient(gomock.NewController(t))
+			nodes := make(map[string]rueidis.Client, len(tt.addrs))
+			for _, addr := range tt.addrs {
+				nodes[addr] = mc
+			}
+			mc.EXPECT().Nodes().Return(nodes).AnyTimes()
+			mc.EXPECT().Do(gomock.Any(), mock.Match("GET", "key")).Return(mock.Result(mock.RedisString("value")))
+			client := WrapClient(mc, WithTransportTag())
+			client.Do(context.Background(), client.B().Get().Key("key").Build())
+
+			spans := mt.FinishedSpans()
+			require.Len(t, spans, 1)
+			assert.Equal(t, tt.transport, spans[0].Tag(TagTransport))
+		})
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		mc := newMockClient(t)
+		mc.EXPECT().Do(gomock.Any(), mock.Match("GET", "key")).Return(mock.Result(mock.RedisString("value")))
+		client := WrapClient(mc)
+		client.Do(context.Background(), client.B().Get().Key("key").Build())
+
+		spans := mt.FinishedSpans()
+		require.Len(t, spans, 1)
+		assert.NotContains(t, spans[0].Tags(), TagTransport)
+	})
+}
+
+func TestTransportTagUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "redis.sock")
+	li, err := net.Listen("unix", path)
+	require.NoError(t, err)
+	defer li.Close()
+	go serveRESP(li)
+
+	for _, addr := range []string{path, "unix://" + path} {
+		t.Run(addr, func(t *testing.T) {
+			mt := mocktracer.Start()
+			defer mt.Stop()
+
+			// rueidis dials TCP unless given a DialFn
+			client, err := NewClient(rueidis.ClientOption{
+				InitAddress:       []string{addr},
+				ForceSingleClient: true,
+				DisableCache:      true,
+				AlwaysRESP2:       true,
+				DialFn: func(dst string, dialer *net.Dialer, _ *tls.Config) (net.Conn, error) {
+					return dialer.Dial("unix", strings.TrimPrefix(dst, "unix://"))
+				},
+			}, WithTransportTag(), WithConnectSpans())
+			require.NoError(t, err)
+			defer client.Close()
+			reply, err := client.Do(context.Background(), client.B().Get().Key("key").Build()).ToString()
+			require.NoError(t, err)
+			assert.Equal(t, "value", reply)
+
+			spans := mt.FinishedSpans()
+			require.NotEmpty(t, spans)
+			for _, span := range spans {
+				assert.Equal(t, "unix", span.Tag(TagTransport))
+				assert.Equal(t, path, span.Tag(ext.TargetHost))
+				assert.NotContains(t, span.Tags(), ext.TargetPort)
+			}
+			last := spans[len(spans)-1]
+			assert.Equal(t, "GET", last.Tag(ext.ResourceName))
+			assert.Equal(t, connectResource, spans[0].Tag(ext.ResourceName))
+		})
+	}
+}
+
+// serveRESP serves the connections accepted by li as a RESP2 server replying
+// "value" to GET and OK to any other command, until li is closed.
+func serveRESP(li net.Listener) {
+	for {
+		conn, err := li.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			r := bufio.NewReader(conn)
+			for {
+				cmd, err := readRESPCommand(r)
+				if err != nil {
+					return
+				}
+				reply := "+OK\r\n"
+				if strings.EqualFold(cmd[0], "GET") {
+					reply = "$5\r\nvalue\r\n"
+				}
+				if _, err := conn.Write([]byte(reply)); err != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+// readRESPCommand reads a command sent as a RESP array of bulk strings.
+func readRESPCommand(r *bufio.Reader) ([]string, error) {
+	readLine := func(prefix byte) (int, error) {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return 0, err
+		}
+		if len(line) < 3 || line[0] != prefix {
+			return 0, fmt.Errorf("unexpected line %q", line)
+		}
+		return strconv.Atoi(strings.TrimSuffix(line[1:], "\r\n"))
+	}
+	n, err := readLine('*')
+	if err != nil {
+		return nil, err
+	}
+	cmd := make([]string, n)
+	for i := range cmd {
+		size, err := readLine('$')
+		if err != nil {
+			return nil, err
+		}
+		arg := make([]byte, size+2)
+		if _, err := io.ReadFull(r, arg); err != nil {
+			return nil, err
+		}
+		cmd[i] = string(arg[:size])
+	}
+	if n == 0 {
+		return nil, errors.New("empty command")
+	}
+	return cmd, nil
+}
+
+func TestCachedResourceSuffix(t *testing.T) {
+	assert := assert.New(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	mc := newMockClient(t)
+	mc.EXPECT().DoCache(gomock.Any(), mock.Match("GET", "key"), time.Minute).Return(mock.Result(mock.RedisString("value"))).Times(2)
+	mc.EXPECT().DoMultiCache(gomock.Any(), gomock.Any()).Return([]rueidis.RedisResult{mock.Result(mock.RedisString("value"))})
+	mc.EXPECT().Do(gomock.Any(), mock.Match("GET", "key")).Return(mock.Result(mock.RedisString("value")))
+	ctx := context.Background()
+	client := WrapClient(mc, WithCachedResourceSuffix())
+	client.DoCache(ctx, client.B().Get().Key("key").Cache(), time.Minute)
+	client.DoMultiCache(ctx, rueidis.CT(client.B().Get().Key("key").Cache(), time.Minute))
+	client.Do(ctx, client.B().Get().Key("key").Build())
+	client = WrapClient(mc)
+	client.DoCache(ctx, client.B().Get().Key("key").Cache(), time.Minute)
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 4)
+	assert.Equal("GET (cached)", spans[0].Tag(ext.ResourceName))
+	assert.Equal("redis.pipeline (cached)", spans[1].Tag(ext.ResourceName))
+	assert.Equal("GET", spans[2].Tag(ext.ResourceName))
+	assert.Equal("GET", spans[3].Tag(ext.ResourceName))
+
+	t.Run("slow", func(t *testing.T) {
+		mt := mocktracer.Start()
+		defer mt.Stop()
+
+		mc := newMockClient(t)
+		mc.EXPECT().DoCache(gomock.Any(), mock.Match("GET", "key"), time.Minute).Return(mock.Result(mock.RedisString("value")))
+		client := WrapClient(mc, WithCachedResourceSuffix(), WithSlowResourceSuffix(time.Nanosecond))
+		client.DoCache(context.Background(), client.B().Get().Key("key").Cache(), time.Minute)
+
+		spans := mt.FinishedSpans()
+		require.Len(t, spans, 1)
+		require.Equal(t, "GET (cached) (slow)", spans[0].Tag(ext.ResourceName))
+	})
+}
+
+func TestIgnoredCommands(t *testing.T) {
+	assert := assert.New(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	mc := newMockClient(t)
+	mc.EXPECT().Do(gomock.Any(), gomock.Any()).Return(mock.Result(mock.RedisString("OK"))).Times(2)
+	mc.EXPECT().DoMulti(gomock.Any(), gomock.Any(), gomock.Any()).Return([]rueidis.RedisResult{
+		mock.Result(mock.RedisString("PONG")),
+		mock.Result(mock.RedisString("value")),
+	})
+	ctx := context.Background()
+	client := WrapClient(mc, WithIgnoredCommands("ping"))
+	client.Do(ctx, client.B().Ping().Build())
+	client.Do(ctx, client.B().Get().Key("key").Build())
+	client.DoMulti(ctx,
+		client.B().Ping().Build(),
+		client.B().Get().Key("key").Build(),
+	)
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 2)
+	assert.Equal("GET", spans[0].Tag(ext.ResourceName))
+	assert.Equal("redis.pipeline", spans[1].Tag(ext.ResourceName))
+}
+
+func TestHookStats(t *testing.T) {
+	assert := assert.New(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	mc := newMockClient(t)
+	mc.EXPECT().Do(gomock.Any(), mock.Match("PING")).Return(mock.Result(mock.RedisString("PONG")))
+	mc.EXPECT().Do(gomock.Any(), mock.Match("GET", "key")).Return(mock.Result(mock.RedisString("value")))
+	mc.EXPECT().Do(gomock.Any(), mock.Match("GET", "missing")).Return(mock.Result(mock.RedisNil()))
+	mc.EXPECT().Do(gomock.Any(), mock.Match("SET", "key", "value")).Return(mock.ErrorResult(errors.New("READONLY")))
+	var stats HookStats
+	ctx := context.Background()
+	client := WrapClient(mc, WithIgnoredCommands("PING"), WithHookStats(&stats))
+	client.Do(ctx, client.B().Ping().Build())
+	client.Do(ctx, client.B().Get().Key("key").Build())
+	client.Do(ctx, client.B().Get().Key("missing").Build())
+	client.Do(ctx, client.B().Set().Key("key").Value("value").Build())
+
+	assert.Len(mt.FinishedSpans(), 3)
+	assert.Equal(int64(3), stats.SpansCreated())
+	assert.Equal(int64(1), stats.SpansSkipped())
+	assert.Equal(int64(1), stats.SpansErrored())
+}
+
+func TestSamplingObserver(t *testing.T) {
+	assert := assert.New(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	type decision struct {
+		verb string
+		kept bool
+	}
+	var decisions []decision
+	mc := newMockClient(t)
+	mc.EXPECT().Do(gomock.Any(), gomock.Any()).Return(mock.Result(mock.RedisString("OK"))).Times(3)
+	mc.EXPECT().DoMulti(gomock.Any(), gomock.Any()).Return([]rueidis.RedisResult{
+		mock.Result(mock.RedisString("value")),
+	})
+	client := WrapClient(mc, WithSamplingObserver(func(verb string, kept bool) {
+		decisions = append(decisions, decision{verb: verb, kept: kept})
+	}))
+
+	keep, ctx := tracer.StartSpanFromContext(context.Background(), "keep", tracer.Tag(ext.SamplingPriority, ext.PriorityUserKeep))
+	client.Do(ctx, client.B().Get().Key("key").Build())
+	client.DoMulti(ctx, client.B().Get().Key("key").Build())
+	keep.Finish()
+	reject, ctx := tracer.StartSpanFromContext(context.Background(), "reject", tracer.Tag(ext.SamplingPriority, ext.PriorityUserReject))
+	client.Do(ctx, client.B().Arbitrary("set").Keys("key").Args("value").Build())
+	reject.Finish()
+	// the mocktracer doesn't make any sampling decision for root spans
+	client.Do(context.Background(), client.B().Ping().Build())
+
+	assert.Equal([]decision{
+		{verb: "GET", kept: true},
+		{verb: "redis.pipeline", kept: true},
+		{verb: "SET", kept: false},
+	}, decisions)
+
+	t.Run("aggregation", func(t *testing.T) {
+		var verbs []string
+		mc := newMockClient(t)
+		mc.EXPECT().Do(gomock.Any(), gomock.Any()).Return(mock.Result(mock.RedisString("value"))).Times(3)
+		client := WrapClient(mc, WithAggregation(time.Hour, 3), WithSamplingObserver(func(verb string, _ bool) {
+			verbs = append(verbs, verb)
+		}))
+		keep, ctx := tracer.StartSpanFromContext(context.Background(), "keep", tracer.Tag(ext.SamplingPriority, ext.PriorityUserKeep))
+		defer keep.Finish()
+		for i := 0; i < 3; i++ {
+			client.Do(ctx, client.B().Get().Key("key").Build())
+		}
+		assert.Equal([]string{"GET"}, verbs)
+	})
+}
+
+func TestPerCommandTags(t *testing.T) {
+	assert := assert.New(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	mc := newMockClient(t)
+	mc.EXPECT().Do(gomock.Any(), gomock.Any()).Return(mock.Result(mock.RedisString("OK"))).Times(2)
+	client := WrapClient(mc, WithPerCommandTags(func(cmd string) []ddtrace.StartSpanOption {
+		if cmd == "SET" {
+			return []ddtrace.StartSpanOption{tracer.Tag("redis.write", true)}
+		}
+		return nil
+	}))
+	ctx := context.Background()
+	client.Do(ctx, client.B().Get().Key("key").Build())
+	client.Do(ctx, client.B().Set().Key("key").Value("value").Build())
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 2)
+	assert.NotContains(spans[0].Tags(), "redis.write")
+	assert.Equal(true, spans[1].Tag("redis.write"))
+	// the static tags are still set
+	assert.Equal("127.0.0.1", spans[1].Tag(ext.TargetHost))
+}
+
+func TestRetryTag(t *testing.T) {
+	assert := assert.New(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	mc := newMockClient(t)
+	mc.EXPECT().Do(gomock.Any(), mock.Match("GET", "key")).Return(mock.Result(mock.RedisString("value")))
+	mc.EXPECT().Do(gomock.Any(), mock.Match("GET", "moved")).Return(mock.Result(mock.RedisError("MOVED 3999 127.0.0.1:6381")))
+	mc.EXPECT().DoMulti(gomock.Any(), gomock.Any(), gomock.Any()).Return([]rueidis.RedisResult{
+		mock.Result(mock.RedisError("ASK 3999 127.0.0.1:6381")),
+		mock.Result(mock.RedisError("TRYAGAIN Multiple keys request during rehashing of slot")),
+	})
+	ctx := context.Background()
+	client := WrapClient(mc, WithRetryTag())
+	client.Do(ctx, client.B().Get().Key("key").Build())
+	client.Do(ctx, client.B().Get().Key("moved").Build())
+	client.DoMulti(ctx,
+		client.B().Get().Key("ask").Build(),
+		client.B().Get().Key("tryagain").Build(),
+	)
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 3)
+	assert.Equal(0, spans[0].Tag("redis.retries"))
+	assert.Equal(1, spans[1].Tag("redis.retries"))
+	assert.Equal(2, spans[2].Tag("redis.retries"))
+}
+
+func TestTagNames(t *testing.T) {
+	assert := assert.New(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	mc := mock.NewClient(gomock.NewController(t))
+	mc.EXPECT().Nodes().Return(map[string]rueidis.Client{"127.0.0.1:6379": mc, "127.0.0.1:6380": mc}).AnyTimes()
+	mc.EXPECT().Do(gomock.Any(), gomock.Any()).Return(mock.Result(mock.RedisString("OK")))
+	client := WrapClient(mc, WithRequestSizeTag(), WithCallerTag())
+	client.Do(context.Background(), client.B().Set().Key("key").Value("value").Build())
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 1)
+	tags := spans[0].Tags()
+	assert.Equal("2", tags[TagArgsLength])
+	assert.Equal("SET key value", tags[TagRawCommand])
+	assert.Equal("127.0.0.1:6379, 127.0.0.1:6380", tags[TagAddrs])
+	assert.Equal(11, tags[TagRequestBytes])
+	assert.Equal("gopkg.in/DataDog/dd-trace-go.v1/contrib/redis/rueidis.TestTagNames", tags[TagCaller])
+	// the tag names are part of the public API and must not change
+	assert.Equal("redis.args_length", TagArgsLength)
+	assert.Equal("redis.raw_command", TagRawCommand)
+	assert.Equal("addrs", TagAddrs)
+}
+
+func TestKeyRedactor(t *testing.T) {
+	assert := assert.New(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	mc := newMockClient(t)
+	mc.EXPECT().Do(gomock.Any(), gomock.Any()).Return(mock.Result(mock.RedisString("OK"))).Times(3)
+	ctx := context.Background()
+	client := WrapClient(mc, WithKeyTag())
+	client.Do(ctx, client.B().Get().Key("session:jane@example.com").Build())
+	client = WrapClient(mc, WithKeyTag(), WithKeyRedactor(regexp.MustCompile(`[^:@]+@[^:]+`), "<email>"))
+	client.Do(ctx, client.B().Get().Key("session:jane@example.com").Build())
+	client.Do(ctx, client.B().Ping().Build())
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 3)
+	assert.Equal("session:jane@example.com", spans[0].Tag(TagKey))
+	assert.Equal("session:<email>", spans[1].Tag(TagKey))
+	assert.NotContains(spans[2].Tags(), TagKey)
+}
+
+func TestWithTracer(t *testing.T) {
+	assert := assert.New(t)
+	custom := mocktracer.Start()
+	global := mocktracer.Start()
+	defer global.Stop()
+
+	mc := newMockClient(t)
+	mc.EXPECT().Do(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, _ rueidis.Completed) rueidis.RedisResult {
+		// the span of the command is the active one
+		span, ok := tracer.SpanFromContext(ctx)
+		assert.True(ok)
+		assert.Equal("GET", span.(mocktracer.Span).Tag(ext.ResourceName))
+		return mock.Result(mock.RedisString("value"))
+	})
+	client := WrapClient(mc, WithTracer(custom.(ddtrace.Tracer)))
+	root, ctx := tracer.StartSpanFromContext(context.Background(), "root")
+	client.Do(ctx, client.B().Get().Key("key").Build())
+	root.Finish()
+
+	spans := custom.FinishedSpans()
+	require.Len(t, spans, 1)
+	assert.Equal("GET", spans[0].Tag(ext.ResourceName))
+	assert.Equal(root.Context().SpanID(), spans[0].ParentID())
+	// only the root span was started with the global tracer
+	require.Len(t, global.FinishedSpans(), 1)
+	assert.Equal("root", global.FinishedSpans()[0].OperationName())
+}
+
+func TestBlockingCommands(t *testing.T) {
+	assert := assert.New(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	mc := newMockClient(t)
+	mc.EXPECT().Do(gomock.Any(), gomock.Any()).Return(mock.Result(mock.RedisNil())).Times(6)
+	ctx := context.Background()
+	client := WrapClient(mc)
+	client.Do(ctx, client.B().Blpop().Key("list").Timeout(1).Build())
+	client.Do(ctx, client.B().Get().Key("key").Build())
+	client.Do(ctx, client.B().Xread().Block(1000).Streams().Key("stream").Id("$").Build())
+	client.Do(ctx, client.B().Xread().Streams().Key("stream").Id("0").Build())
+	client = WrapClient(mc, WithBlockingCommands("get"))
+	client.Do(ctx, client.B().Blpop().Key("list").Timeout(1).Build())
+	client.Do(ctx, client.B().Get().Key("key").Build())
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 6)
+	assert.Equal(true, spans[0].Tag(TagBlocking))
+	assert.NotContains(spans[1].Tags(), TagBlocking)
+	assert.Equal(true, spans[2].Tag(TagBlocking))
+	assert.NotContains(spans[3].Tags(), TagBlocking)
+	assert.NotContains(spans[4].Tags(), TagBlocking)
+	assert.Equal(true, spans[5].Tag(TagBlocking))
+}
+
+func TestOnlyWithinTrace(t *testing.T) {
+	assert := assert.New(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	mc := newMockClient(t)
+	mc.EXPECT().Do(gomock.Any(), gomock.Any()).Return(mock.Result(mock.RedisString("OK"))).Times(2)
+	client := WrapClient(mc, WithOnlyWithinTrace())
+	client.Do(context.Background(), client.B().Get().Key("key").Build())
+	assert.Empty(mt.FinishedSpans())
+
+	root, ctx := tracer.StartSpanFromContext(context.Background(), "root")
+	client.Do(ctx, client.B().Get().Key("key").Build())
+	root.Finish()
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 2)
+	assert.Equal("GET", spans[0].Tag(ext.ResourceName))
+	assert.Equal(root.Context().SpanID(), spans[0].ParentID())
+}
+
+func TestSlowResourceSuffix(t *testing.T) {
+	assert := assert.New(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	clock := &fakeClock{t: time.Now()}
+	mc := newMockClient(t)
+	mc.EXPECT().Do(gomock.Any(), mock.Match("GET", "slow")).DoAndReturn(func(context.Context, rueidis.Completed) rueidis.RedisResult {
+		clock.advance(20 * time.Millisecond)
+		return mock.Result(mock.RedisString("value"))
+	})
+	mc.EXPECT().Do(gomock.Any(), mock.Match("GET", "limit")).DoAndReturn(func(context.Context, rueidis.Completed) rueidis.RedisResult {
+		clock.advance(10 * time.Millisecond)
+		return mock.Result(mock.RedisString("value"))
+	})
+	mc.EXPECT().Do(gomock.Any(), mock.Match("GET", "fast")).Return(mock.Result(mock.RedisString("value")))
+	ctx := context.Background()
+	client := WrapClient(mc, withClock(clock), WithSlowResourceSuffix(10*time.Millisecond))
+	client.Do(ctx, client.B().Get().Key("slow").Build())
+	client.Do(ctx, client.B().Get().Key("limit").Build())
+	client.Do(ctx, client.B().Get().Key("fast").Build())
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 3)
+	assert.Equal("GET (slow)", spans[0].Tag(ext.ResourceName))
+	assert.Equal(20*time.Millisecond, spans[0].FinishTime().Sub(spans[0].StartTime()))
+	// only the commands lasting longer than the threshold are slow
+	assert.Equal("GET", spans[1].Tag(ext.ResourceName))
+	assert.Equal("GET", spans[2].Tag(ext.ResourceName))
+}
+
+func TestDatabaseIndex(t *testing.T) {
+	assert := assert.New(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	mc := newMockClient(t)
+	mc.EXPECT().Do(gomock.Any(), gomock.Any()).Return(mock.Result(mock.RedisString("OK"))).Times(5)
+	ctx := context.Background()
+	client := WrapClient(mc, WithOTelConventions())
+	client.Do(ctx, client.B().Get().Key("key").Build())
+	client = WrapClient(mc, WithDatabaseIndex(0), WithOTelConventions())
+	client.Do(ctx, client.B().Get().Key("key").Build())
+	client = WrapClient(mc, WithDatabaseIndex(15), WithOTelConventions())
+	client.Do(ctx, client.B().Get().Key("key").Build())
+	// the tag is only set along with the OpenTelemetry conventions
+	client = WrapClient(mc, WithDatabaseIndex(0))
+	client.Do(ctx, client.B().Get().Key("key").Build())
+	client = WrapClient(mc, WithDatabaseIndex(15))
+	client.Do(ctx, client.B().Get().Key("key").Build())
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 5)
+	assert.NotContains(spans[0].Tags(), ext.RedisDatabaseIndex)
+	assert.Equal(0, spans[1].Tag(ext.RedisDatabaseIndex))
+	assert.Equal(15, spans[2].Tag(ext.RedisDatabaseIndex))
+	assert.NotContains(spans[3].Tags(), ext.RedisDatabaseIndex)
+	assert.NotContains(spans[4].Tags(), ext.RedisDatabaseIndex)
+}
+
+func TestClientName(t *testing.T) {
+	assert := assert.New(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	mc := newMockClient(t)
+	mc.EXPECT().Do(gomock.Any(), gomock.Any()).Return(mock.Result(mock.RedisString("OK"))).Times(2)
+	ctx := context.Background()
+	client := WrapClient(mc)
+	client.Do(ctx, client.B().Get().Key("key").Build())
+	client = WrapClient(mc, WithClientName("worker"))
+	client.Do(ctx, client.B().Get().Key("key").Build())
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 2)
+	assert.NotContains(spans[0].Tags(), TagClientName)
+	assert.Equal("worker", spans[1].Tag(TagClientName))
+}
+
+func TestReadPolicyTag(t *testing.T) {
+	assert := assert.New(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	mc := newMockClient(t)
+	mc.EXPECT().Do(gomock.Any(), gomock.Any()).Return(mock.Result(mock.RedisString("OK"))).Times(2)
+	ctx := context.Background()
+	client := WrapClient(mc)
+	client.Do(ctx, client.B().Get().Key("key").Build())
+	client = WrapClient(mc, WithReadPolicyTag("nearest"))
+	client.Do(ctx, client.B().Get().Key("key").Build())
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 2)
+	assert.NotContains(spans[0].Tags(), TagReadPolicy)
+	assert.Equal("nearest", spans[1].Tag(TagReadPolicy))
+}
+
+// connectionClient is a rueidis.Client identifying its connection.
+type connectionClient struct {
+	*mock.Client
+	id string
+}
+
+func (c connectionClient) ConnectionID() string { return c.id }
+
+func TestConnectionIDTag(t *testing.T) {
+	assert := assert.New(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	mc := newMockClient(t)
+	mc.EXPECT().Do(gomock.Any(), gomock.Any()).Return(mock.Result(mock.RedisString("OK"))).Times(3)
+	ctx := context.Background()
+	client := WrapClient(connectionClient{Client: mc, id: "conn-1"})
+	client.Do(ctx, client.B().Get().Key("key").Build())
+	client = WrapClient(connectionClient{Client: mc, id: "conn-1"}, WithConnectionIDTag())
+	client.Do(ctx, client.B().Get().Key("key").Build())
+	// the connection is unknown
+	client = WrapClient(mc, WithConnectionIDTag())
+	client.Do(ctx, client.B().Get().Key("key").Build())
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 3)
+	assert.NotContains(spans[0].Tags(), TagConnectionID)
+	assert.Equal("conn-1", spans[1].Tag(TagConnectionID))
+	assert.NotContains(spans[2].Tags(), TagConnectionID)
+}
+
+type queueDepthClient struct {
+	*mock.Client
+	depth int
+}
+
+func (c queueDepthClient) QueueDepth() int { return c.depth }
+
+func TestQueueDepthTag(t *testing.T) {
+	assert := assert.New(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	mc := newMockClient(t)
+	mc.EXPECT().Do(gomock.Any(), gomock.Any()).Return(mock.Result(mock.RedisString("OK"))).Times(3)
+	ctx := context.Background()
+	client := WrapClient(queueDepthClient{Client: mc, depth: 42})
+	client.Do(ctx, client.B().Get().Key("key").Build())
+	client = WrapClient(queueDepthClient{Client: mc, depth: 42}, WithQueueDepthTag())
+	client.Do(ctx, client.B().Get().Key("key").Build())
+	// the queue depth is unknown
+	client = WrapClient(mc, WithQueueDepthTag())
+	client.Do(ctx, client.B().Get().Key("key").Build())
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 3)
+	assert.NotContains(spans[0].Tags(), TagQueueDepth)
+	assert.Equal(42, spans[1].Tag(TagQueueDepth))
+	assert.NotContains(spans[2].Tags(), TagQueueDepth)
+}
+
+type generationClient struct {
+	*mock.Client
+	generation *uint64
+}
+
+func (c generationClient) ConnectionGeneration() uint64 { return atomic.LoadUint64(c.generation) }
+
+func TestReconnectDetection(t *testing.T) {
+	assert := assert.New(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	var generation uint64
+	mc := newMockClient(t)
+	mc.EXPECT().Do(gomock.Any(), mock.Match("GET", "key")).Return(mock.Result(mock.RedisString("value"))).Times(2)
+	mc.EXPECT().Do(gomock.Any(), mock.Match("SET", "key", "value")).DoAndReturn(func(context.Context, rueidis.Completed) rueidis.RedisResult {
+		// the connection dropped and the client reconnected
+		atomic.AddUint64(&generation, 1)
+		return mock.Result(mock.RedisString("OK"))
+	})
+	ctx := context.Background()
+	client := WrapClient(generationClient{Client: mc, generation: &generation}, WithReconnectDetection())
+	client.Do(ctx, client.B().Get().Key("key").Build())
+	client.Do(ctx, client.B().Set().Key("key").Value("value").Build())
+	// the generation is unknown
+	client = WrapClient(mc, WithReconnectDetection())
+	client.Do(ctx, client.B().Get().Key("key").Build())
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 3)
+	assert.Equal(false, spans[0].Tag(TagReconnected))
+	assert.Equal(true, spans[1].Tag(TagReconnected))
+	assert.NotContains(spans[2].Tags(), TagReconnected)
+}
+
+func TestSourceLabel(t *testing.T) {
+	assert := assert.New(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	mc := newMockClient(t)
+	mc.EXPECT().Do(gomock.Any(), gomock.Any()).Return(mock.Result(mock.RedisString("OK"))).Times(2)
+	ctx := context.Background()
+	client := WrapClient(mc)
+	client.Do(ctx, client.B().Get().Key("key").Build())
+	client = WrapClient(mc, WithSourceLabel("rate_limiter"))
+	client.Do(ctx, client.B().Get().Key("key").Build())
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 2)
+	assert.NotContains(spans[0].Tags(), TagSource)
+	assert.Equal("rate_limiter", spans[1].Tag(TagSource))
+}
+
+func TestDataset(t *testing.T) {
+	assert := assert.New(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	mc := newMockClient(t)
+	mc.EXPECT().Do(gomock.Any(), gomock.Any()).Return(mock.Result(mock.RedisString("OK"))).Times(2)
+	mc.EXPECT().DoMulti(gomock.Any(), gomock.Any()).Return([]rueidis.RedisResult{mock.Result(mock.RedisString("OK"))})
+	ctx := context.Background()
+	client := WrapClient(mc)
+	client.Do(ctx, client.B().Get().Key("key").Build())
+	client = WrapClient(mc, WithDataset("sessions"))
+	client.Do(ctx, client.B().Get().Key("key").Build())
+	client.DoMulti(ctx, client.B().Get().Key("key").Build())
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 3)
+	assert.NotContains(spans[0].Tags(), TagDataset)
+	assert.Equal("sessions", spans[1].Tag(TagDataset))
+	assert.Equal("sessions", spans[2].Tag(TagDataset))
+}
+
+func TestClientInfoTrace(t *testing.T) {
+	assert := assert.New(t)
+	option := rueidis.ClientOption{InitAddress: []string{"127.0.0.1:6379"}}
+
+	assert.Nil(withClientInfo(option, newConfig(WithServiceName("my-redis"))).ClientSetInfo)
+	assert.Equal(
+		[]string{"rueidis(dd-trace-go_my-redis)", rueidis.LibVer},
+		withClientInfo(option, newConfig(WithServiceName("my-redis"), WithClientInfoTrace())).ClientSetInfo,
+	)
+	assert.Equal(
+		[]string{"rueidis(dd-trace-go_my_redis)", rueidis.LibVer},
+		withClientInfo(option, newConfig(WithServiceName("my redis"), WithClientInfoTrace())).ClientSetInfo,
+	)
+
+	// the configured library info is kept
+	option.ClientSetInfo = []string{"my-lib", "1.0.0"}
+	assert.Equal([]string{"my-lib", "1.0.0"}, withClientInfo(option, newConfig(WithClientInfoTrace())).ClientSetInfo)
+}
+
+func TestSpanIDsFromContext(t *testing.T) {
+	assert := assert.New(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	traceID, spanID := SpanIDsFromContext(context.Background())
+	assert.Zero(traceID)
+	assert.Zero(spanID)
+
+	mc := newMockClient(t)
+	mc.EXPECT().Do(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, _ rueidis.Completed) rueidis.RedisResult {
+		// the wrapped client, such as a logging hook, gets the span of the command
+		traceID, spanID = SpanIDsFromContext(ctx)
+		return mock.ErrorResult(errors.New("timeout"))
+	})
+	client := WrapClient(mc)
+	client.Do(context.Background(), client.B().Get().Key("key").Build())
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 1)
+	assert.Equal(spans[0].TraceID(), traceID)
+	assert.Equal(spans[0].SpanID(), spanID)
+}
+
+func TestNoTrace(t *testing.T) {
+	assert := assert.New(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	mc := newMockClient(t)
+	mc.EXPECT().Do(gomock.Any(), gomock.Any()).Return(mock.Result(mock.RedisString("OK"))).Times(2)
+	var stats HookStats
+	client := WrapClient(mc, WithHookStats(&stats))
+	client.Do(WithNoTrace(context.Background()), client.B().Get().Key("untraced").Build())
+	client.Do(context.Background(), client.B().Get().Key("traced").Build())
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 1)
+	assert.Equal("GET traced", spans[0].Tag(TagRawCommand))
+	assert.Equal(int64(1), stats.SpansSkipped())
+}
+
+func TestNodeZoneMapper(t *testing.T) {
+	assert := assert.New(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	ctrl := gomock.NewController(t)
+	nodes := map[string]rueidis.Client{}
+	for _, addr := range []string{"10.0.0.1:6379", "10.0.0.2:6379"} {
+		node := mock.NewClient(ctrl)
+		node.EXPECT().Nodes().Return(map[string]rueidis.Client{addr: node}).AnyTimes()
+		node.EXPECT().Do(gomock.Any(), gomock.Any()).Return(mock.Result(mock.RedisString("PONG")))
+		nodes[addr] = node
+	}
+	mc := mock.NewClient(ctrl)
+	mc.EXPECT().Nodes().DoAndReturn(func() map[string]rueidis.Client {
+		m := make(map[string]rueidis.Client, len(nodes))
+		for addr, node := range nodes {
+			m[addr] = node
+		}
+		return m
+	}).AnyTimes()
+	mc.EXPECT().Do(gomock.Any(), gomock.Any()).Return(mock.Result(mock.RedisString("PONG")))
+
+	client := WrapClient(mc, WithNodeZoneMapper(func(addr string) string {
+		return map[string]string{
+			"10.0.0.1:6379": "us-east-1a",
+			"10.0.0.2:6379": "us-east-1b",
+		}[addr]
+	}))
+	ctx := context.Background()
+	for _, node := range client.Nodes() {
+		node.Do(ctx, node.B().Ping().Build())
+	}
+	// the node serving a command sent through the cluster client is unknown
+	client.Do(ctx, client.B().Ping().Build())
+
+	zones := []interface{}{}
+	for _, s := range mt.FinishedSpans() {
+		zones = append(zones, s.Tag(TagNodeZone))
+	}
+	assert.ElementsMatch([]interface{}{"us-east-1a", "us-east-1b", nil}, zones)
+}
+
+// timing is a histogram sample recorded by a statsdRecorder.
+type timing struct {
+	event    string
+	duration time.Duration
+	tags     []string
+}
+
+// statsdRecorder is a StatsdClient recording the timings it receives.
+type statsdRecorder struct {
+	timings []timing
+}
+
+func (r *statsdRecorder) Timing(event string, duration time.Duration, tags []string, _ float64) error {
+	r.timings = append(r.timings, timing{event: event, duration: duration, tags: tags})
+	return nil
+}
+
+func TestStatsd(t *testing.T) {
+	assert := assert.New(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	mc := newMockClient(t)
+	mc.EXPECT().Do(gomock.Any(), mock.Match("GET", "key")).Return(mock.Result(mock.RedisString("value")))
+	mc.EXPECT().Do(gomock.Any(), mock.Match("SET", "key", "value")).Return(mock.ErrorResult(errors.New("timeout")))
+	var statsd statsdRecorder
+	client := WrapClient(mc, WithStatsd(&statsd))
+	ctx := context.Background()
+	client.Do(ctx, client.B().Get().Key("key").Build())
+	client.Do(ctx, client.B().Set().Key("key").Value("value").Build())
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 2)
+	require.Len(t, statsd.timings, 2)
+	for i, tm := range statsd.timings {
+		assert.Equal("rueidis.command.duration", tm.event)
+		assert.Equal(spans[i].FinishTime().Sub(spans[i].StartTime()), tm.duration)
+	}
+	assert.Equal([]string{"verb:GET", "error:false"}, statsd.timings[0].tags)
+	assert.Equal([]string{"verb:SET", "error:true"}, statsd.timings[1].tags)
+}
+
+// prometheusRecorder is a PrometheusRegistry recording the metrics it receives,
+// keyed by their name and verb label.
+type prometheusRecorder struct {
+	counters   map[string]int
+	histograms map[string][]float64
+}
+
+func newPrometheusRecorder() *prometheusRecorder {
+	return &prometheusRecorder{
+		counters:   make(map[string]int),
+		histograms: make(map[string][]float64),
+	}
+}
+
+func (r *prometheusRecorder) IncCounter(name string, labels map[string]string) {
+	r.counters[name+"{verb="+labels["verb"]+"}"]++
+}
+
+func (r *prometheusRecorder) ObserveHistogram(name string, value float64, labels map[string]string) {
+	key := name + "{verb=" + labels["verb"] + "}"
+	r.histograms[key] = append(r.histograms[key], value)
+}
+
+func TestPrometheusRegistry(t *testing.T) {
+	assert := assert.New(t)
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	mc := newMockClient(t)
+	mc.EXPECT().Do(gomock.Any(), mock.Match("GET", "key")).Return(mock.Result(mock.RedisString("value"))).Times(2)
+	mc.EXPECT().Do(gomock.Any(), mock.Match("SET", "key", "value")).Return(mock.ErrorResult(errors.New("timeout")))
+	mc.EXPECT().DoMulti(gomock.Any(), gomock.Any(), gomock.Any()).Return([]rueidis.RedisResult{
+		mock.Result(mock.RedisString("OK")),
+		mock.Result(mock.RedisString("value")),
+	})
+	registry := newPrometheusRecorder()
+	client := WrapClient(mc, WithPrometheusRegistry(registry))
+	ctx := context.Background()
+	client.Do(ctx, client.B().Get().Key("key").Build())
+	client.Do(ctx, client.B().Get().Key("key").Build())
+	client.Do(ctx, client.B().Set().Key("key").Value("value").Build())
+	client.DoMulti(ctx, client.B().Set().Key("key").Value("value").Build(), client.B().Get().Key("key").Build())
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 4)
+	assert.Equal(map[string]int{
+		"rueidis_commands_total{verb=GET}":            2,
+		"rueidis_commands_total{verb=SET}":            1,
+		"rueidis_commands_total{verb=redis.pipeline}": 1,
+		"rueidis_command_errors_total{verb=SET}":      1,
+	}, registry.counters)
+	durations := registry.histograms["rueidis_command_duration_seconds{verb=GET}"]
+	require.Len(t, durations, 2)
+	for i, d := range durations {
+		assert.Equal(spans[i].FinishTime().Sub(spans[i].StartTime()).Seconds(), d)
+	}
+	assert.Len(registry.histograms["rueidis_command_duration_seconds{verb=SET}"], 1)
+	assert.Len(registry.histograms["rueidis_command_duration_seconds{verb=redis.pipeline}"], 1)
+}
+
+type discardLogger struct{}
+
+func (discardLogger) Log(_ string) {}
+
+func TestGlobalTags(t *testing.T) {
+	// the global tags are set by the tracer itself, which the mocktracer doesn't do
+	t.Setenv("DD_TAGS", "team:storage,tier:cache")
+	tracer.Start(tracer.WithLogger(discardLogger{}), tracer.WithLogStartup(false))
+	defer tracer.Stop()
+
+	var span string
+	mc := newMockClient(t)
+	mc.EXPECT().Do(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, _ rueidis.Completed) rueidis.RedisResult {
+		s, _ := tracer.SpanFromContext(ctx)
+		span = fmt.Sprintf("%s", s)
+		return mock.Result(mock.RedisString("PONG"))
+	})
+	client := WrapClient(mc)
+	client.Do(context.Background(), client.B().Ping().Build())
+
+	assert.Contains(t, span, "Name: redis.command")
+	assert.Contains(t, span, "\tteam:storage")
+	assert.Contains(t, span, "\ttier:cache")
+}
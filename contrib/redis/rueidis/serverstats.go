@@ -0,0 +1,132 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016 Datadog, Inc.
+
+package rueidis
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/DataDog/dd-trace-go.v1/internal/log"
+
+	"github.com/redis/rueidis"
+)
+
+// commandStats holds the statistics reported by the server for a command.
+type commandStats struct {
+	calls       int64
+	usecPerCall float64
+}
+
+// serverStats periodically polls the server's INFO commandstats section and
+// keeps the last reported statistics of every command.
+type serverStats struct {
+	client rueidis.Client
+
+	mu    sync.RWMutex
+	stats map[string]commandStats
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// startServerStats starts polling the statistics of client every interval in the background.
+// The given client must not be traced, to avoid tracing the polling commands.
+func startServerStats(client rueidis.Client, interval time.Duration) *serverStats {
+	s := &serverStats{
+		client: client,
+		stop:   make(chan struct{}),
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		tick := time.NewTicker(interval)
+		defer tick.Stop()
+		for {
+			s.poll(interval)
+			select {
+			case <-tick.C:
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+	return s
+}
+
+// poll fetches the statistics from the server, giving up after timeout.
+func (s *serverStats) poll(timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	info, err := s.client.Do(ctx, s.client.B().Info().Section("commandstats").Build()).ToString()
+	if err != nil {
+		log.Debug("contrib/redis/rueidis: failed to poll the server command stats: %v", err)
+		return
+	}
+	stats := parseCommandStats(info)
+	s.mu.Lock()
+	s.stats = stats
+	s.mu.Unlock()
+}
+
+// get returns the last statistics reported for the command with the given resource name.
+func (s *serverStats) get(resource string) (commandStats, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	st, ok := s.stats[resource]
+	return st, ok
+}
+
+// Stop stops polling the server and waits for the polling goroutine to return.
+func (s *serverStats) Stop() {
+	s.stopOnce.Do(func() { close(s.stop) })
+	s.wg.Wait()
+}
+
+// parseCommandStats parses the content of the INFO commandstats section, made of
+// lines such as "cmdstat_get:calls=21,usec=175,usec_per_call=8.33". The returned
+// statistics are keyed by the resource name of the command, so that subcommands
+// such as "cmdstat_client|list" are found under "CLIENT LIST".
+func parseCommandStats(info string) map[string]commandStats {
+	stats := make(map[string]commandStats)
+	for _, line := range strings.Split(info, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "cmdstat_") {
+			continue
+		}
+		name, fields, ok := strings.Cut(strings.TrimPrefix(line, "cmdstat_"), ":")
+		if !ok {
+			continue
+		}
+		var st commandStats
+		for _, field := range strings.Split(fields, ",") {
+			k, v, _ := strings.Cut(field, "=")
+			switch k {
+			case "calls":
+				st.calls, _ = strconv.ParseInt(v, 10, 64)
+			case "usec_per_call":
+				st.usecPerCall, _ = strconv.ParseFloat(v, 64)
+			}
+		}
+		stats[strings.ToUpper(strings.ReplaceAll(name, "|", " "))] = st
+	}
+	return stats
+}
+
+// serverStatsClient is a rueidis.Client which stops polling the server statistics
+// when it is closed.
+type serverStatsClient struct {
+	rueidis.Client
+	stats *serverStats
+}
+
+func (c *serverStatsClient) Close() {
+	c.stats.Stop()
+	c.Client.Close()
+}
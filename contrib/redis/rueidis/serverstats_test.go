@@ -0,0 +1,62 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016 Datadog, Inc.
+
+package rueidis
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/mocktracer"
+
+	"github.com/golang/mock/gomock"
+	"github.com/redis/rueidis/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const commandStatsInfo = "# Commandstats\r\n" +
+	"cmdstat_get:calls=21,usec=175,usec_per_call=8.33,rejected_calls=0,failed_calls=0\r\n" +
+	"cmdstat_client|list:calls=2,usec=50,usec_per_call=25.00,rejected_calls=0,failed_calls=0\r\n"
+
+func TestParseCommandStats(t *testing.T) {
+	stats := parseCommandStats(commandStatsInfo)
+	assert.Equal(t, map[string]commandStats{
+		"GET":         {calls: 21, usecPerCall: 8.33},
+		"CLIENT LIST": {calls: 2, usecPerCall: 25},
+	}, stats)
+}
+
+func TestServerStats(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	mc := newMockClient(t)
+	mc.EXPECT().Do(gomock.Any(), mock.Match("INFO", "commandstats")).Return(mock.Result(mock.RedisString(commandStatsInfo))).MinTimes(1)
+	mc.EXPECT().Do(gomock.Any(), mock.Match("GET", "key")).Return(mock.Result(mock.RedisString("value")))
+	mc.EXPECT().Do(gomock.Any(), mock.Match("SET", "key", "value")).Return(mock.Result(mock.RedisString("OK")))
+	mc.EXPECT().Close()
+	client := WrapClient(mc, WithServerStats(time.Hour))
+
+	stats := client.(*serverStatsClient).stats
+	require.Eventually(t, func() bool {
+		_, ok := stats.get("GET")
+		return ok
+	}, time.Second, 10*time.Millisecond)
+
+	ctx := context.Background()
+	client.Do(ctx, client.B().Get().Key("key").Build())
+	client.Do(ctx, client.B().Set().Key("key").Value("value").Build())
+	client.Close()
+
+	// the polling commands are not traced
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 2)
+	assert.Equal(t, int64(21), spans[0].Tag("redis.server.calls"))
+	assert.Equal(t, 8.33, spans[0].Tag("redis.server.usec_per_call"))
+	assert.NotContains(t, spans[1].Tags(), "redis.server.calls")
+	assert.NotContains(t, spans[1].Tags(), "redis.server.usec_per_call")
+}
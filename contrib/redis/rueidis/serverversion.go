@@ -0,0 +1,62 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016 Datadog, Inc.
+
+package rueidis
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/DataDog/dd-trace-go.v1/internal/log"
+
+	"github.com/redis/rueidis"
+)
+
+// serverVersionTimeout is the time after which fetching the server version is given up.
+const serverVersionTimeout = 5 * time.Second
+
+// serverVersion holds the version of the server a client is connected to, once known.
+type serverVersion struct {
+	version atomic.Value // string
+}
+
+// fetchServerVersion fetches the version of the server from its INFO server
+// section in the background. The given client must not be traced, to avoid
+// tracing the INFO command.
+func fetchServerVersion(client rueidis.Client) *serverVersion {
+	s := new(serverVersion)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), serverVersionTimeout)
+		defer cancel()
+		info, err := client.Do(ctx, client.B().Info().Section("server").Build()).ToString()
+		if err != nil {
+			log.Debug("contrib/redis/rueidis: failed to fetch the server version: %v", err)
+			return
+		}
+		if v := parseServerVersion(info); v != "" {
+			s.version.Store(v)
+		}
+	}()
+	return s
+}
+
+// get returns the version of the server, if it is known.
+func (s *serverVersion) get() (string, bool) {
+	v, ok := s.version.Load().(string)
+	return v, ok
+}
+
+// parseServerVersion returns the version found in the content of the INFO server
+// section, in its "redis_version:7.2.0" line.
+func parseServerVersion(info string) string {
+	for _, line := range strings.Split(info, "\n") {
+		if line = strings.TrimSpace(line); strings.HasPrefix(line, "redis_version:") {
+			return strings.TrimPrefix(line, "redis_version:")
+		}
+	}
+	return ""
+}
@@ -0,0 +1,74 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016 Datadog, Inc.
+
+package rueidis
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/mocktracer"
+
+	"github.com/golang/mock/gomock"
+	"github.com/redis/rueidis"
+	"github.com/redis/rueidis/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const serverInfo = "# Server\r\n" +
+	"redis_version:7.2.0\r\n" +
+	"redis_git_sha1:00000000\r\n" +
+	"redis_mode:standalone\r\n"
+
+func TestParseServerVersion(t *testing.T) {
+	assert.Equal(t, "7.2.0", parseServerVersion(serverInfo))
+	assert.Equal(t, "", parseServerVersion("# Server\r\nredis_mode:standalone\r\n"))
+}
+
+func TestServerVersionTag(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	mc := newMockClient(t)
+	mc.EXPECT().Do(gomock.Any(), mock.Match("INFO", "server")).Return(mock.Result(mock.RedisString(serverInfo)))
+	mc.EXPECT().Do(gomock.Any(), mock.Match("GET", "key")).Return(mock.Result(mock.RedisString("value"))).AnyTimes()
+	client := WrapClient(mc, WithServerVersionTag())
+
+	// the version is fetched in the background
+	require.Eventually(t, func() bool {
+		mt.Reset()
+		client.Do(context.Background(), client.B().Get().Key("key").Build())
+		spans := mt.FinishedSpans()
+		return len(spans) == 1 && spans[0].Tag("redis.server_version") != nil
+	}, time.Second, 10*time.Millisecond)
+
+	// the INFO command is not traced
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "7.2.0", spans[0].Tag("redis.server_version"))
+}
+
+func TestServerVersionTagUnavailable(t *testing.T) {
+	mt := mocktracer.Start()
+	defer mt.Stop()
+
+	mc := newMockClient(t)
+	fetched := make(chan struct{})
+	mc.EXPECT().Do(gomock.Any(), mock.Match("INFO", "server")).DoAndReturn(func(_ context.Context, _ rueidis.Completed) rueidis.RedisResult {
+		defer close(fetched)
+		return mock.ErrorResult(errors.New("NOPERM"))
+	})
+	mc.EXPECT().Do(gomock.Any(), mock.Match("GET", "key")).Return(mock.Result(mock.RedisString("value")))
+	client := WrapClient(mc, WithServerVersionTag())
+	<-fetched
+	client.Do(context.Background(), client.B().Get().Key("key").Build())
+
+	spans := mt.FinishedSpans()
+	require.Len(t, spans, 1)
+	assert.NotContains(t, spans[0].Tags(), "redis.server_version")
+}
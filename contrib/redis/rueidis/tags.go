@@ -0,0 +1,150 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016 Datadog, Inc.
+
+package rueidis
+
+// The keys of the tags set by this package on the spans of the traced commands.
+const (
+	// TagArgsLength is the number of arguments of the command.
+	TagArgsLength = "redis.args_length"
+	// TagRawCommand is the command along with its arguments.
+	TagRawCommand = "redis.raw_command"
+	// TagKey is the first key of the command, see WithKeyTag.
+	TagKey = "redis.key"
+	// TagKeysCount is the number of keys of the command, see WithKeysCountTag.
+	TagKeysCount = "redis.keys_count"
+	// TagIdempotent reports whether the command is idempotent, see
+	// WithIdempotencyTag.
+	TagIdempotent = "redis.idempotent"
+	// TagKeyslot is the cluster hash slot of the first key of the command, see
+	// WithKeyslotTag.
+	TagKeyslot = "redis.keyslot"
+	// TagAddrs is the list of the addresses of the nodes the client is connected
+	// to, when there are more than one.
+	TagAddrs = "addrs"
+	// TagBlocking is set to true for blocking commands, see WithBlockingCommands.
+	TagBlocking = "redis.blocking"
+	// TagChannel is the channel of a received message, see WithMessageSpans.
+	TagChannel = "redis.channel"
+	// TagChannels is the number of channels or patterns a SUBSCRIBE,
+	// UNSUBSCRIBE, PSUBSCRIBE, PUNSUBSCRIBE, SSUBSCRIBE or SUNSUBSCRIBE command
+	// is given.
+	TagChannels = "redis.channels"
+	// TagPattern is the pattern matching the channel of a received message, see
+	// WithMessageSpans.
+	TagPattern = "redis.pattern"
+	// TagKeyspaceEvent is the event type of a received keyspace notification,
+	// see WithMessageSpans.
+	TagKeyspaceEvent = "redis.keyspace_event"
+	// TagPipelineSize is the number of commands sent together with DoMulti or
+	// DoMultiCache.
+	TagPipelineSize = "redis.pipeline_size"
+	// TagCommandsPerFlush is the number of commands written to the server per
+	// round trip by DoMulti or DoMultiCache, see WithCommandsPerFlushTag.
+	TagCommandsPerFlush = "redis.commands_per_flush"
+	// TagClientName is the name of the client, see WithClientName.
+	TagClientName = "redis.client_name"
+	// TagQueueDepth is the number of outstanding requests of the client when the
+	// command is sent, see WithQueueDepthTag.
+	TagQueueDepth = "redis.queue_depth"
+	// TagReconnected reports whether the client reconnected to the server while
+	// the command was running, see WithReconnectDetection.
+	TagReconnected = "redis.reconnected"
+	// TagFunction is the name of the function called by FCALL and FCALL_RO
+	// commands, see WithFunctionResource.
+	TagFunction = "redis.function"
+	// TagConnectionID is the identifier of the connection serving the command,
+	// see WithConnectionIDTag.
+	TagConnectionID = "redis.connection_id"
+	// TagReadPolicy is the policy of the client choosing the nodes serving the
+	// read commands, see WithReadPolicyTag.
+	TagReadPolicy = "redis.read_policy"
+	// TagConsistency is the consistency mode expected by the command, see
+	// WithConsistencyTag.
+	TagConsistency = "redis.consistency"
+	// TagSource is the subsystem which issued the command, see WithSourceLabel.
+	TagSource = "redis.source"
+	// TagNodeZone is the availability zone of the node serving the command, see
+	// WithNodeZoneMapper.
+	TagNodeZone = "redis.node_az"
+	// TagCacheHit reports whether the reply was served from the client side cache.
+	TagCacheHit = "redis.cache_hit"
+	// TagTracking reports whether the command went through the client side
+	// cache, see WithTrackingTag.
+	TagTracking = "redis.tracking"
+	// TagTimeout is the time left in milliseconds before the deadline of the
+	// context of the command when it was sent, see WithTimeoutTag.
+	TagTimeout = "redis.timeout_ms"
+	// TagTransactionAborted reports whether the transaction of an EXEC command
+	// was aborted, see WithTransactionAbortedTag.
+	TagTransactionAborted = "redis.transaction_aborted"
+	// TagResultType is the RESP type of the reply of the command, see
+	// WithResultTypeTag.
+	TagResultType = "redis.result_type"
+	// TagRequestBytes is the size in bytes of the command, see WithRequestSizeTag.
+	TagRequestBytes = "redis.request_bytes"
+	// TagCaller is the function which issued the command, see WithCallerTag.
+	TagCaller = "redis.caller"
+	// TagServerCalls is the number of calls of the command reported by the
+	// server, see WithServerStats.
+	TagServerCalls = "redis.server.calls"
+	// TagServerUsecPerCall is the average duration of the command in
+	// microseconds reported by the server, see WithServerStats.
+	TagServerUsecPerCall = "redis.server.usec_per_call"
+	// TagServerVersion is the version of the server, see WithServerVersionTag.
+	TagServerVersion = "redis.server_version"
+	// TagRESPVersion is the version of the RESP protocol negotiated with the
+	// server, see WithRESPVersionTag.
+	TagRESPVersion = "redis.resp_version"
+	// TagRetries is the number of replies the client retries on, see WithRetryTag.
+	TagRetries = "redis.retries"
+	// TagErrorCategory is the category of the error of the command, see
+	// WithErrorCategorizer.
+	TagErrorCategory = "redis.error_category"
+	// TagTLSHandshake is the duration of the TLS handshake of the connection
+	// to the server, in microseconds, see WithConnectSpans.
+	TagTLSHandshake = "redis.tls_handshake_us"
+	// TagServerLoading reports whether the server rejected the command because
+	// it is loading its dataset, see WithServerLoadingTag.
+	TagServerLoading = "redis.server_loading"
+	// TagAggregatedCount is the number of commands represented by the span, see
+	// WithAggregation.
+	TagAggregatedCount = "redis.aggregated_count"
+	// TagAggregatedErrors is the number of commands represented by the span,
+	// other than the one it was started for, which failed, see WithAggregation.
+	TagAggregatedErrors = "redis.aggregated_errors"
+	// TagServerProcessing is the duration of the command minus the round trip
+	// time to the server, in milliseconds, see WithBaselineRTT.
+	TagServerProcessing = "redis.server_processing_ms"
+	// TagFailover is set to true on the spans of the commands completed while a
+	// failover is happening, see WithFailoverDetection.
+	TagFailover = "redis.failover"
+	// TagAllocBytes is the number of bytes allocated on the heap while the
+	// command was running, see WithAllocTracking.
+	TagAllocBytes = "redis.alloc_bytes"
+	// TagOTelStatusCode is the OpenTelemetry status code of the spans finished
+	// with an error, see WithOTelConventions.
+	TagOTelStatusCode = "otel.status_code"
+	// TagOTelStatusDescription is the OpenTelemetry status description of the
+	// spans finished with an error, see WithOTelConventions.
+	TagOTelStatusDescription = "otel.status_description"
+	// TagHookOverhead is the time spent by the instrumentation tracing the
+	// command, in microseconds, see WithHookOverheadTag.
+	TagHookOverhead = "redis.hook_overhead_us"
+	// TagGoroutineID is the identifier of the goroutine which issued the
+	// command, see WithSchedulerTags.
+	TagGoroutineID = "redis.goroutine_id"
+	// TagGOMAXPROCS is the GOMAXPROCS setting when the command was issued, see
+	// WithSchedulerTags.
+	TagGOMAXPROCS = "redis.gomaxprocs"
+	// TagCacheEntryBytes is the approximate size in bytes of the replies held in
+	// the client side cache, see WithCacheSizeTag.
+	TagCacheEntryBytes = "redis.cache_entry_bytes"
+	// TagTransport is the transport of the connection to the server, "unix" or
+	// "tcp", see WithTransportTag.
+	TagTransport = "redis.transport"
+	// TagDataset is the logical dataset held by the server, see WithDataset.
+	TagDataset = "redis.dataset"
+)
@@ -43,6 +43,10 @@ type (
 		instrumentation.TagsHolder
 		instrumentation.SecurityEventsHolder
 		Error error
+		// MessageIndex is the index in the stream of the last received message
+		// on which security events were observed, or -1 when there were none.
+		// It is set with SetMessageIndex.
+		MessageIndex int
 	}
 	// HandlerOperationArgs is the grpc handler arguments.
 	HandlerOperationArgs struct {
@@ -68,6 +72,10 @@ type (
 		// Message received by the gRPC handler.
 		// Corresponds to the address `grpc.server.request.message`.
 		Message interface{}
+		// MessageIndex is the index of the message in the stream, as returned
+		// by StreamMessageCounter.Next, or -1 when it couldn't be received or
+		// when the RPC isn't a streaming one.
+		MessageIndex int
 	}
 )
 
@@ -79,8 +87,9 @@ type (
 // root operation.
 func StartHandlerOperation(ctx context.Context, args HandlerOperationArgs, parent dyngo.Operation) (context.Context, *HandlerOperation) {
 	op := &HandlerOperation{
-		Operation:  dyngo.NewOperation(parent),
-		TagsHolder: instrumentation.NewTagsHolder(),
+		Operation:    dyngo.NewOperation(parent),
+		TagsHolder:   instrumentation.NewTagsHolder(),
+		MessageIndex: -1,
 	}
 	newCtx := context.WithValue(ctx, instrumentation.ContextKey{}, op)
 	dyngo.StartOperation(op, args)
@@ -94,6 +103,17 @@ func (op *HandlerOperation) Finish(res HandlerOperationRes) []json.RawMessage {
 	return op.Events()
 }
 
+// SetMessageIndex records the index of a received message on which security
+// events were observed, as given by its ReceiveOperationRes, keeping the highest
+// one in MessageIndex. Negative indexes, of the messages which couldn't be
+// received, are ignored. The receive operations of a stream being sequential,
+// it must be called by their finish event listeners.
+func (op *HandlerOperation) SetMessageIndex(index int) {
+	if index > op.MessageIndex {
+		op.MessageIndex = index
+	}
+}
+
 // gRPC handler operation's start and finish event callback function types.
 type (
 	// OnHandlerOperationStart function type, called when an gRPC handler
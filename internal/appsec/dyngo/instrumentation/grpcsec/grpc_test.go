@@ -11,6 +11,8 @@ import (
 	"fmt"
 	"testing"
 
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/appsec/dyngo"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/appsec/dyngo/instrumentation/grpcsec"
 
@@ -84,3 +86,95 @@ func TestUsage(t *testing.T) {
 	// messages), and bidirectional RPCs like client streaming RPCs (N client
 	// messages, M server messages).
 }
+
+func TestSetMessageIndex(t *testing.T) {
+	type (
+		rootArgs struct{}
+		rootRes  struct{}
+	)
+	localRootOp := dyngo.NewOperation(nil)
+	dyngo.StartOperation(localRootOp, rootArgs{})
+	defer dyngo.FinishOperation(localRootOp, rootRes{})
+
+	// security events are observed on the messages containing "attack", as the
+	// WAF event listener does
+	localRootOp.On(grpcsec.OnHandlerOperationStart(func(handlerOp *grpcsec.HandlerOperation, _ grpcsec.HandlerOperationArgs) {
+		handlerOp.On(grpcsec.OnReceiveOperationStart(func(op grpcsec.ReceiveOperation, _ grpcsec.ReceiveOperationArgs) {
+			op.On(grpcsec.OnReceiveOperationFinish(func(_ grpcsec.ReceiveOperation, res grpcsec.ReceiveOperationRes) {
+				if res.Message == "attack" {
+					handlerOp.AddSecurityEvents(json.RawMessage(`["event"]`))
+					handlerOp.SetMessageIndex(res.MessageIndex)
+				}
+			}))
+		}))
+	}))
+
+	for _, tc := range []struct {
+		name     string
+		messages []grpcsec.ReceiveOperationRes
+		want     int
+	}{
+		{
+			name:     "no-events",
+			messages: []grpcsec.ReceiveOperationRes{{Message: "hello", MessageIndex: 0}},
+			want:     -1,
+		},
+		{
+			name:     "unary",
+			messages: []grpcsec.ReceiveOperationRes{{Message: "attack", MessageIndex: -1}},
+			want:     -1,
+		},
+		{
+			name: "last-offending-message",
+			messages: []grpcsec.ReceiveOperationRes{
+				{Message: "attack", MessageIndex: 0},
+				{Message: "hello", MessageIndex: 2},
+				{Message: "attack", MessageIndex: 4},
+				{Message: "hello", MessageIndex: 6},
+				// the io.EOF of the end of the stream
+				{Message: "attack", MessageIndex: -1},
+			},
+			want: 4,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, rpcOp := grpcsec.StartHandlerOperation(context.Background(), grpcsec.HandlerOperationArgs{}, localRootOp)
+			for _, res := range tc.messages {
+				grpcsec.StartReceiveOperation(grpcsec.ReceiveOperationArgs{}, rpcOp).Finish(res)
+			}
+			rpcOp.Finish(grpcsec.HandlerOperationRes{})
+			require.Equal(t, tc.want, rpcOp.MessageIndex)
+		})
+	}
+}
+
+func TestEventSpanEnvVersion(t *testing.T) {
+	tracer.Start(
+		tracer.WithService("grpc-service"),
+		tracer.WithEnv("staging"),
+		tracer.WithServiceVersion("1.2.3"),
+		tracer.WithLogger(discardLogger{}),
+	)
+	defer tracer.Stop()
+
+	// the tracer sets the env and version tags on the security event span,
+	// which inherits the service of the service entry span
+	var eventSpan ddtrace.Span
+	startSpan := func(operationName string, opts ...ddtrace.StartSpanOption) ddtrace.Span {
+		eventSpan = tracer.StartSpan(operationName, opts...)
+		return eventSpan
+	}
+	entrySpan := tracer.StartSpan("grpc.server")
+	defer entrySpan.Finish()
+	grpcsec.SetSecurityEventTags(entrySpan, []json.RawMessage{json.RawMessage(`["one","two"]`)}, nil, grpcsec.WithEventSpan(startSpan))
+
+	require.NotNil(t, eventSpan)
+	// the tags of the span are only exposed by its debugging representation
+	repr := fmt.Sprintf("%s", eventSpan)
+	require.Contains(t, repr, "\tenv:staging\n")
+	require.Contains(t, repr, "\tversion:1.2.3\n")
+}
+
+type discardLogger struct{}
+
+func (discardLogger) Log(string) {}
@@ -6,30 +6,828 @@
 package grpcsec
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/x509"
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
 
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/appsec/dyngo/instrumentation"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/appsec/dyngo/instrumentation/httpsec"
 	"gopkg.in/DataDog/dd-trace-go.v1/internal/log"
+
+	"github.com/DataDog/appsec-internal-go/netip"
+)
+
+type (
+	// SecurityEventTagsOption configures how SetSecurityEventTags sets the
+	// security event tags.
+	SecurityEventTagsOption func(*securityEventTagsConfig)
+
+	securityEventTagsConfig struct {
+		startSpan    SpanStarter
+		requestSize  int
+		responseSize int
+		ruleIDs      bool
+		messageIndex int
+		fullMethod   string
+		limiter      *MethodRateLimiter
+		method       string
+		schema       interface{}
+		maxEventSize int
+		monitoring   bool
+		params       []MatchedParam
+		peerTLS      PeerTLS
+	}
+
+	// SpanStarter is a function starting a new span, such as tracer.StartSpan.
+	SpanStarter func(operationName string, opts ...ddtrace.StartSpanOption) ddtrace.Span
 )
 
+// EventSpanName is the operation name of the span holding the security events
+// when WithEventSpan is used.
+const EventSpanName = "appsec.event"
+
+// WithEventSpan makes SetSecurityEventTags set the security events on a
+// dedicated EventSpanName child span of the service entry span, started with
+// start, rather than on the service entry span itself. This avoids growing the
+// service entry span with very large security event payloads. Only the
+// "_dd.appsec.json" tag is set on the event span: the "appsec.event",
+// "manual.keep" and "_dd.origin" tags, which the backend relies on to find and
+// keep the security traces, are still set on the service entry span. When start
+// is tracer.StartSpan, the event span inherits the service of the service entry
+// span, and gets the same env and version tags from the tracer.
+func WithEventSpan(start SpanStarter) SecurityEventTagsOption {
+	return func(cfg *securityEventTagsConfig) {
+		cfg.startSpan = start
+	}
+}
+
+// WithMessageSizes makes SetSecurityEventTags set the "grpc.request.length" and
+// "grpc.response.length" tags on the service entry span to the given sizes in
+// bytes of the request and response messages. Zero sizes are considered unknown
+// and are not set.
+func WithMessageSizes(request, response int) SecurityEventTagsOption {
+	return func(cfg *securityEventTagsConfig) {
+		cfg.requestSize = request
+		cfg.responseSize = response
+	}
+}
+
+// maxRuleIDs is the maximum number of rule ids set in the "appsec.rule_ids" tag.
+const maxRuleIDs = 32
+
+// WithRuleIDs makes SetSecurityEventTags set the "appsec.rule_ids" tag next to
+// the security events, holding the comma-separated list of the distinct ids of the
+// rules which matched, up to maxRuleIDs of them.
+func WithRuleIDs() SecurityEventTagsOption {
+	return func(cfg *securityEventTagsConfig) {
+		cfg.ruleIDs = true
+	}
+}
+
+// WithMessageIndex makes SetSecurityEventTags set the "grpc.message_index" tag
+// next to the security events, holding the index of the last message of a
+// streaming RPC on which they were observed, as returned by
+// StreamMessageCounter.Next. The events of all the messages being set together,
+// the tag doesn't tell which message triggered which event.
+func WithMessageIndex(index int) SecurityEventTagsOption {
+	return func(cfg *securityEventTagsConfig) {
+		cfg.messageIndex = index
+	}
+}
+
+// WithMethod makes SetSecurityEventTags set the "rpc.service" and "rpc.method"
+// tags on the service entry span, parsed from the given full method name of the
+// RPC in the "/package.Service/Method" form. The full method name itself is set
+// as the "rpc.grpc.full_method" and "http.route" tags, so that security events
+// are grouped by endpoint the same way as for HTTP requests. Nothing is set when
+// it is malformed.
+func WithMethod(fullMethod string) SecurityEventTagsOption {
+	return func(cfg *securityEventTagsConfig) {
+		cfg.fullMethod = fullMethod
+	}
+}
+
+// splitMethod splits the given full method name in the "/package.Service/Method"
+// form into its service and method names.
+func splitMethod(fullMethod string) (service, method string, ok bool) {
+	if !strings.HasPrefix(fullMethod, "/") {
+		return "", "", false
+	}
+	service, method, ok = strings.Cut(fullMethod[1:], "/")
+	if !ok || service == "" || method == "" || strings.Contains(method, "/") {
+		return "", "", false
+	}
+	return service, method, true
+}
+
+// WithMethodRateLimit makes SetSecurityEventTags consult limiter before setting
+// the security event tags of an RPC of the given method, its full method name.
+// When the limit of the method is reached, only the "_dd.appsec.rate_limited"
+// tag is set on the service entry span. This avoids flooding the backend when the
+// same method triggers many security events, such as under attack.
+func WithMethodRateLimit(limiter *MethodRateLimiter, method string) SecurityEventTagsOption {
+	return func(cfg *securityEventTagsConfig) {
+		cfg.limiter = limiter
+		cfg.method = method
+	}
+}
+
+// maxMethodWindows is the maximum number of methods whose spans are counted
+// separately by a MethodRateLimiter, the method names being sent by the clients.
+const maxMethodWindows = 1024
+
+// MethodRateLimiter limits the number of spans whose security event tags are set
+// per second and per gRPC method. Past maxMethodWindows methods seen within the
+// last second, the spans of the other methods are counted together against a
+// single limit. It is safe for concurrent use.
+type MethodRateLimiter struct {
+	limit int
+	now   func() time.Time
+
+	mu       sync.Mutex
+	windows  map[string]*methodWindow
+	overflow *methodWindow
+	swept    time.Time
+}
+
+// methodWindow counts the spans allowed for a method since start.
+type methodWindow struct {
+	start time.Time
+	count int
+}
+
+// NewMethodRateLimiter returns a MethodRateLimiter allowing up to perSecond spans
+// per second and per method. There is no limit when perSecond is 0 or less.
+func NewMethodRateLimiter(perSecond int) *MethodRateLimiter {
+	return &MethodRateLimiter{
+		limit:   perSecond,
+		now:     time.Now,
+		windows: make(map[string]*methodWindow),
+	}
+}
+
+// Allow reports whether the security event tags of a span of the given method
+// can be set, counting it if so.
+func (l *MethodRateLimiter) Allow(method string) bool {
+	if l.limit <= 0 {
+		return true
+	}
+	now := l.now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.swept) >= time.Second {
+		// drop the windows of the methods not seen within the last second
+		for m, w := range l.windows {
+			if now.Sub(w.start) >= time.Second {
+				delete(l.windows, m)
+			}
+		}
+		l.swept = now
+	}
+	w, ok := l.windows[method]
+	overflow := !ok && len(l.windows) >= maxMethodWindows
+	if overflow {
+		w, ok = l.overflow, l.overflow != nil
+	}
+	if !ok || now.Sub(w.start) >= time.Second {
+		w = &methodWindow{start: now}
+		if overflow {
+			l.overflow = w
+		} else {
+			l.windows[method] = w
+		}
+	}
+	if w.count >= l.limit {
+		return false
+	}
+	w.count++
+	return true
+}
+
+// maxRequestSchemaSize is the maximum size in bytes of the encoded request schema
+// set in the "_dd.appsec.s.req.body" tag.
+const maxRequestSchemaSize = 25000
+
+// WithRequestSchema makes SetSecurityEventTags set the "_dd.appsec.s.req.body"
+// tag on the service entry span to the given schema of the request message, such
+// as the types of the fields of the protobuf message, as the HTTP API security
+// does with the request body. The schema is JSON encoded, gzipped and base64
+// encoded, and it is not set when its encoding is larger than
+// maxRequestSchemaSize bytes.
+func WithRequestSchema(schema interface{}) SecurityEventTagsOption {
+	return func(cfg *securityEventTagsConfig) {
+		cfg.schema = schema
+	}
+}
+
+// encodeSchema returns the base64 encoding of the gzipped JSON encoding of schema.
+func encodeSchema(schema interface{}) (string, error) {
+	var buf bytes.Buffer
+	b64 := base64.NewEncoder(base64.StdEncoding, &buf)
+	gz := gzip.NewWriter(b64)
+	if err := json.NewEncoder(gz).Encode(schema); err != nil {
+		return "", err
+	}
+	if err := gz.Close(); err != nil {
+		return "", err
+	}
+	if err := b64.Close(); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// WithMaxEventSize makes SetSecurityEventTags cap the "_dd.appsec.json" tag to
+// max bytes. The tag is truncated by dropping the last triggers that don't fit,
+// keeping it valid JSON, and the "_dd.appsec.json.truncated" tag is set to
+// "triggers". When not even the first trigger fits, the tag is cut at the last
+// UTF-8 character boundary before max bytes, resulting in invalid JSON, and the
+// "_dd.appsec.json.truncated" tag is set to "bytes".
+func WithMaxEventSize(max int) SecurityEventTagsOption {
+	return func(cfg *securityEventTagsConfig) {
+		cfg.maxEventSize = max
+	}
+}
+
+// WithMonitoringMode makes SetSecurityEventTags set the "_dd.appsec.monitored"
+// tag to "true" alongside the security events, to tell that AppSec monitored the
+// RPC without blocking it. It allows distinguishing the monitored-only events
+// from the ones of blocked RPCs, for which it must not be used.
+func WithMonitoringMode() SecurityEventTagsOption {
+	return func(cfg *securityEventTagsConfig) {
+		cfg.monitoring = true
+	}
+}
+
+// MatchedParam is a parameter of the request message which matched a security
+// rule.
+type MatchedParam struct {
+	// Path is the path of the parameter in the request message, such as
+	// "user.name".
+	Path string
+	// Value is the value of the parameter which matched the rule.
+	Value string
+}
+
+const (
+	// maxMatchedParamValueSize is the maximum size in bytes of the values of
+	// the "appsec.matched_params" tag, longer values being truncated.
+	maxMatchedParamValueSize = 64
+	// maxMatchedParamsSize is the maximum size in bytes of the
+	// "appsec.matched_params" tag, the parameters beyond it being dropped.
+	maxMatchedParamsSize = 1024
+	// redactedValue replaces the sensitive values of the matched parameters.
+	redactedValue = "<redacted>"
+)
+
+// sensitiveParamRegexp matches the paths of the parameters whose values are
+// sensitive. It is the default key obfuscation regular expression of the WAF.
+var sensitiveParamRegexp = regexp.MustCompile(`(?i)(?:p(?:ass)?w(?:or)?d|pass(?:_?phrase)?|secret|(?:api_?|private_?|public_?)key)|token|consumer_?(?:id|key|secret)|sign(?:ed|ature)|bearer|authorization`)
+
+// WithMatchedParams makes SetSecurityEventTags set the "appsec.matched_params"
+// tag alongside the security events to the given parameters which matched a
+// rule, as a comma-separated list of "path=value" entries. Duplicate entries are
+// removed, values longer than 64 bytes are truncated, and the values of the
+// parameters whose path looks sensitive, such as "user.password", are redacted.
+// The tag is limited to 1024 bytes, the parameters beyond it being dropped.
+func WithMatchedParams(params ...MatchedParam) SecurityEventTagsOption {
+	return func(cfg *securityEventTagsConfig) {
+		cfg.params = append(cfg.params, params...)
+	}
+}
+
+// matchedParamsTag returns the value of the "appsec.matched_params" tag of the
+// given parameters, see WithMatchedParams.
+func matchedParamsTag(params []MatchedParam) string {
+	var (
+		b    strings.Builder
+		seen = make(map[string]bool, len(params))
+	)
+	for _, p := range params {
+		value := p.Value
+		if sensitiveParamRegexp.MatchString(p.Path) {
+			value = redactedValue
+		} else {
+			value = truncateUTF8(value, maxMatchedParamValueSize)
+		}
+		entry := p.Path + "=" + value
+		if seen[entry] {
+			continue
+		}
+		seen[entry] = true
+		size := len(entry)
+		if b.Len() > 0 {
+			size++
+		}
+		if b.Len()+size > maxMatchedParamsSize {
+			break
+		}
+		if b.Len() > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(entry)
+	}
+	return b.String()
+}
+
+// PeerTLS is the identity of the peer of a mutually authenticated TLS
+// connection.
+type PeerTLS struct {
+	// Subject is the subject of the peer certificate, such as "CN=client".
+	Subject string
+	// SPIFFEID is the SPIFFE id of the peer, such as
+	// "spiffe://example.org/service".
+	SPIFFEID string
+}
+
+// PeerTLSFromCertificate returns the identity of the peer with the given
+// certificate, its SPIFFE id being its first URI SAN with the spiffe scheme.
+func PeerTLSFromCertificate(cert *x509.Certificate) PeerTLS {
+	if cert == nil {
+		return PeerTLS{}
+	}
+	p := PeerTLS{Subject: cert.Subject.String()}
+	for _, uri := range cert.URIs {
+		if uri.Scheme == "spiffe" {
+			p.SPIFFEID = uri.String()
+			break
+		}
+	}
+	return p
+}
+
+// WithPeerTLS makes SetSecurityEventTags set the "tls.peer.subject" and
+// "tls.peer.spiffe_id" tags on the service entry span to the given identity of
+// the peer of the RPC, which is security-relevant when the connection is
+// mutually authenticated. The tags are omitted when empty.
+func WithPeerTLS(peer PeerTLS) SecurityEventTagsOption {
+	return func(cfg *securityEventTagsConfig) {
+		cfg.peerTLS = peer
+	}
+}
+
+// eventSpanTags is a TagSetter setting the "_dd.appsec.json" tags on the event
+// span, and the other security event tags on the service entry span, see
+// WithEventSpan.
+type eventSpanTags struct {
+	entry, event instrumentation.TagSetter
+}
+
+func (t eventSpanTags) SetTag(key string, value interface{}) {
+	if strings.HasPrefix(key, "_dd.appsec.json") {
+		t.event.SetTag(key, value)
+	} else {
+		t.entry.SetTag(key, value)
+	}
+}
+
+// eventSizeLimiter is a TagSetter truncating the "_dd.appsec.json" tag to max
+// bytes, see WithMaxEventSize.
+type eventSizeLimiter struct {
+	instrumentation.TagSetter
+	max int
+}
+
+func (l eventSizeLimiter) SetTag(key string, value interface{}) {
+	if s, ok := value.(string); ok && key == "_dd.appsec.json" && len(s) > l.max {
+		truncated, valid := truncateEventJSON(s, l.max)
+		log.Debug("appsec: truncating the security events of %d bytes to %d bytes", len(s), len(truncated))
+		if valid {
+			l.TagSetter.SetTag("_dd.appsec.json.truncated", "triggers")
+		} else {
+			l.TagSetter.SetTag("_dd.appsec.json.truncated", "bytes")
+		}
+		value = truncated
+	}
+	l.TagSetter.SetTag(key, value)
+}
+
+// truncateEventJSON truncates the given "_dd.appsec.json" tag value to at most
+// max bytes. It keeps as many of its leading triggers as possible and reports
+// whether the result is valid JSON, which is not the case when none of them fits
+// and the value is cut at the last UTF-8 character boundary instead.
+func truncateEventJSON(value string, max int) (string, bool) {
+	if len(value) <= max {
+		return value, true
+	}
+	var event struct {
+		Triggers []json.RawMessage `json:"triggers"`
+	}
+	if err := json.Unmarshal([]byte(value), &event); err == nil {
+		for n := len(event.Triggers) - 1; n > 0; n-- {
+			event.Triggers = event.Triggers[:n]
+			if b, err := json.Marshal(event); err == nil && len(b) <= max {
+				return string(b), true
+			}
+		}
+	}
+	return truncateUTF8(value, max), false
+}
+
+// truncateUTF8 truncates s to at most max bytes without splitting a multibyte
+// UTF-8 character.
+func truncateUTF8(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	if max <= 0 {
+		return ""
+	}
+	i := max
+	// back off to the start of the character spanning the cut, if any
+	for i > 0 && !utf8.RuneStart(s[i]) {
+		i--
+	}
+	return s[:i]
+}
+
+// StreamMessageCounter counts the messages received and sent on a streaming RPC
+// in order to index them. It is safe for concurrent use.
+type StreamMessageCounter struct {
+	count int64
+}
+
+// Next returns the index of the next message of the stream, starting from 0.
+func (c *StreamMessageCounter) Next() int {
+	return int(atomic.AddInt64(&c.count, 1) - 1)
+}
+
+// SetWAFTimingTags sets the "_dd.appsec.waf.duration" and
+// "_dd.appsec.waf.duration_ext" tags on the service entry span to the given WAF
+// run times, in microseconds, as the HTTP integrations do. runtime is the time
+// spent by the WAF itself, and totalRuntime the overall time of the WAF call,
+// including the encoding of its inputs.
+func SetWAFTimingTags(span ddtrace.Span, runtime, totalRuntime time.Duration) {
+	if span == nil {
+		log.Debug("appsec: cannot set the WAF timing tags on a nil span")
+		return
+	}
+	span.SetTag("_dd.appsec.waf.duration", float64(runtime.Nanoseconds())/1e3)          // ns to us
+	span.SetTag("_dd.appsec.waf.duration_ext", float64(totalRuntime.Nanoseconds())/1e3) // ns to us
+}
+
+// baselineMetadata is the list of the request metadata always collected by
+// SetRequestMetadataTags, sorted for deterministic tagging.
+var baselineMetadata = []string{
+	"content-type",
+	"grpc-timeout",
+	"user-agent",
+}
+
+// SetRequestMetadataTags sets the "grpc.metadata.<key>" tags of the baseline
+// request metadata on the service entry span, regardless of any security event,
+// so that every request carries them as the HTTP integrations do with their
+// standard request headers. Only the user-agent, grpc-timeout and content-type
+// metadata are collected, and those absent from md are not set.
+func SetRequestMetadataTags(span ddtrace.Span, md map[string][]string) {
+	if span == nil {
+		log.Debug("appsec: cannot set the request metadata tags on a nil span")
+		return
+	}
+	if len(md) == 0 {
+		return
+	}
+	normalized := make(map[string][]string, len(md))
+	for k, v := range md {
+		k = strings.ToLower(k)
+		normalized[k] = append(normalized[k], v...)
+	}
+	for _, k := range baselineMetadata {
+		if v := normalized[k]; len(v) > 0 {
+			span.SetTag("grpc.metadata."+k, strings.Join(v, ","))
+		}
+	}
+}
+
+// BlockDecision is the outcome of the evaluation of the client IP of a request
+// against the IP denylists.
+type BlockDecision struct {
+	// Blocked is true when the request is blocked.
+	Blocked bool
+	// ClientIP is the client IP which matched the denylist.
+	ClientIP netip.Addr
+	// ListID is the id of the denylist the client IP matched.
+	ListID string
+}
+
+// SetBlockDecisionTags sets the "appsec.blocked", "actor.ip" and
+// "appsec.blocked.list_id" tags on the service entry span when the given
+// decision blocks the request. Nothing is set when the request is allowed, and
+// the client IP and list id are omitted when they are unknown.
+func SetBlockDecisionTags(span ddtrace.Span, d BlockDecision) {
+	if span == nil {
+		log.Debug("appsec: cannot set the block decision tags on a nil span")
+		return
+	}
+	if !d.Blocked {
+		return
+	}
+	span.SetTag(instrumentation.BlockedRequestTag, true)
+	if d.ClientIP.IsValid() {
+		span.SetTag("actor.ip", d.ClientIP.String())
+	}
+	if d.ListID != "" {
+		span.SetTag("appsec.blocked.list_id", d.ListID)
+	}
+}
+
+// SetUserID sets the "usr.id" tag on the service entry span to the id of the
+// authenticated user of the RPC, so that its security events are attributed to
+// the user, as tracer.SetUser does for HTTP requests. Nothing is set when the id
+// is empty, which is the case when the user isn't authenticated.
+func SetUserID(span ddtrace.Span, id string) {
+	if span == nil {
+		log.Debug("appsec: cannot set the user id tag on a nil span")
+		return
+	}
+	if id == "" {
+		return
+	}
+	span.SetTag("usr.id", id)
+}
+
 // SetSecurityEventTags sets the AppSec-specific span tags when a security event
 // occurred into the service entry span.
-func SetSecurityEventTags(span ddtrace.Span, events []json.RawMessage, md map[string][]string) {
-	if err := setSecurityEventTags(span, events, md); err != nil {
+func SetSecurityEventTags(span ddtrace.Span, events []json.RawMessage, md map[string][]string, opts ...SecurityEventTagsOption) {
+	if err := setSecurityEventTags(span, events, md, opts...); err != nil {
 		log.Error("appsec: %v", err)
 	}
 }
 
-func setSecurityEventTags(span ddtrace.Span, events []json.RawMessage, md map[string][]string) error {
-	if err := instrumentation.SetEventSpanTags(span, events); err != nil {
+func setSecurityEventTags(span ddtrace.Span, events []json.RawMessage, md map[string][]string, opts ...SecurityEventTagsOption) error {
+	if span == nil {
+		log.Debug("appsec: cannot set the security event tags on a nil span")
+		return nil
+	}
+	cfg := securityEventTagsConfig{messageIndex: -1}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	if cfg.limiter != nil && !cfg.limiter.Allow(cfg.method) {
+		span.SetTag("_dd.appsec.rate_limited", true)
+		return nil
+	}
+
+	eventSpan := span
+	if cfg.startSpan != nil {
+		eventSpan = cfg.startSpan(EventSpanName, func(c *ddtrace.StartSpanConfig) {
+			c.Parent = span.Context()
+		})
+		defer eventSpan.Finish()
+	}
+	var eventTags instrumentation.TagSetter = eventSpan
+	if eventSpan != span {
+		eventTags = eventSpanTags{entry: span, event: eventSpan}
+	}
+	if cfg.maxEventSize > 0 {
+		eventTags = eventSizeLimiter{TagSetter: eventTags, max: cfg.maxEventSize}
+	}
+	if err := instrumentation.SetEventSpanTags(eventTags, events); err != nil {
 		return err
 	}
+	if cfg.monitoring {
+		eventSpan.SetTag("_dd.appsec.monitored", "true")
+	}
+	if len(cfg.params) > 0 {
+		if tag := matchedParamsTag(cfg.params); tag != "" {
+			eventSpan.SetTag("appsec.matched_params", tag)
+		}
+	}
+	if cfg.messageIndex >= 0 {
+		eventSpan.SetTag("grpc.message_index", cfg.messageIndex)
+	}
+	if cfg.ruleIDs {
+		if ids := ruleIDs(events); len(ids) > 0 {
+			eventSpan.SetTag("appsec.rule_ids", strings.Join(ids, ","))
+		}
+	}
 
 	for h, v := range httpsec.NormalizeHTTPHeaders(md) {
 		span.SetTag("grpc.metadata."+h, v)
 	}
+	if cfg.peerTLS.Subject != "" {
+		span.SetTag("tls.peer.subject", cfg.peerTLS.Subject)
+	}
+	if cfg.peerTLS.SPIFFEID != "" {
+		span.SetTag("tls.peer.spiffe_id", cfg.peerTLS.SPIFFEID)
+	}
+	if cfg.fullMethod != "" {
+		if service, method, ok := splitMethod(cfg.fullMethod); ok {
+			span.SetTag("rpc.service", service)
+			span.SetTag("rpc.method", method)
+			span.SetTag("rpc.grpc.full_method", cfg.fullMethod)
+			span.SetTag(ext.HTTPRoute, cfg.fullMethod)
+		} else {
+			log.Debug("appsec: ignoring the malformed gRPC method name %q", cfg.fullMethod)
+		}
+	}
+	if cfg.schema != nil {
+		schema, err := encodeSchema(cfg.schema)
+		if err != nil {
+			return fmt.Errorf("unexpected error while encoding the request schema: %v", err)
+		}
+		if len(schema) <= maxRequestSchemaSize {
+			span.SetTag("_dd.appsec.s.req.body", schema)
+		} else {
+			log.Debug("appsec: not setting the request schema of %d bytes, larger than %d bytes", len(schema), maxRequestSchemaSize)
+		}
+	}
+	if cfg.requestSize > 0 {
+		span.SetTag("grpc.request.length", cfg.requestSize)
+	}
+	if cfg.responseSize > 0 {
+		span.SetTag("grpc.response.length", cfg.responseSize)
+	}
 
 	return nil
 }
+
+// SecurityEventTagsBuilder accumulates the security events and request metadata
+// computed by several layers of an interceptor chain, so that their tags are set
+// once on the service entry span with Apply, rather than serializing the events
+// and overwriting the tags at every layer. The zero value is ready to use and it
+// is safe for concurrent use.
+type SecurityEventTagsBuilder struct {
+	mu     sync.Mutex
+	events []json.RawMessage
+	md     map[string][]string
+}
+
+// AddEvents adds the given security events.
+func (b *SecurityEventTagsBuilder) AddEvents(events ...json.RawMessage) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.events = append(b.events, events...)
+}
+
+// AddMetadata adds the given request metadata, the values of keys already added
+// being appended to the previous ones.
+func (b *SecurityEventTagsBuilder) AddMetadata(md map[string][]string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.md == nil {
+		b.md = make(map[string][]string, len(md))
+	}
+	for k, v := range md {
+		b.md[k] = append(b.md[k], v...)
+	}
+}
+
+// Apply sets the security event tags of the accumulated events and metadata on
+// the service entry span, as SetSecurityEventTags does. Nothing is set when no
+// events were added.
+func (b *SecurityEventTagsBuilder) Apply(span ddtrace.Span, opts ...SecurityEventTagsOption) {
+	b.mu.Lock()
+	events, md := b.events, b.md
+	b.mu.Unlock()
+	if len(events) == 0 {
+		return
+	}
+	SetSecurityEventTags(span, events, md, opts...)
+}
+
+// maxStreamEvents is the maximum number of security events flushed by a
+// StreamEventAccumulator, the events of the latest messages beyond it being
+// dropped.
+const maxStreamEvents = 256
+
+// StreamEventAccumulator accumulates the security events of the WAF runs of a
+// streaming RPC, the WAF running on every message of the stream, so that their
+// tags are set once on the service entry span with Flush when the stream ends.
+// The zero value is ready to use and it is safe for concurrent use.
+type StreamEventAccumulator struct {
+	mu      sync.Mutex
+	runs    []streamRun
+	flushed bool
+}
+
+// streamRun holds the security events of the WAF run on the message of a stream
+// with the given index.
+type streamRun struct {
+	index  int
+	events []json.RawMessage
+}
+
+// AddRun adds the security events of the WAF run on the message of the stream
+// with the given index, as returned by StreamMessageCounter.Next. The runs added
+// once the events are flushed are ignored.
+func (a *StreamEventAccumulator) AddRun(index int, events ...json.RawMessage) {
+	if len(events) == 0 {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.flushed {
+		log.Debug("appsec: ignoring the security events of message %d of a stream already flushed", index)
+		return
+	}
+	a.runs = append(a.runs, streamRun{index: index, events: events})
+}
+
+// Flush sets the security event tags of the accumulated events on the service
+// entry span, as SetSecurityEventTags does, ordered by the index of the message
+// of their run. Only the first maxStreamEvents events are kept, and the
+// "_dd.appsec.events_dropped" tag is set to the number of dropped events, if
+// any. The size of the events can be further capped with WithMaxEventSize. Only
+// the first call flushes the events, so that it can be called both when the
+// stream is canceled and when it ends. Nothing is set when no events were added.
+func (a *StreamEventAccumulator) Flush(span ddtrace.Span, md map[string][]string, opts ...SecurityEventTagsOption) {
+	a.mu.Lock()
+	runs, flushed := a.runs, a.flushed
+	a.runs, a.flushed = nil, true
+	a.mu.Unlock()
+	if flushed || len(runs) == 0 {
+		return
+	}
+	sort.SliceStable(runs, func(i, j int) bool { return runs[i].index < runs[j].index })
+	var (
+		events  []json.RawMessage
+		dropped int
+	)
+	for _, run := range runs {
+		n := len(run.events)
+		if room := maxStreamEvents - len(events); n > room {
+			n = room
+		}
+		events = append(events, run.events[:n]...)
+		dropped += len(run.events) - n
+	}
+	if dropped > 0 && span != nil {
+		log.Debug("appsec: dropping %d security events of the stream beyond %d events", dropped, maxStreamEvents)
+		span.SetTag("_dd.appsec.events_dropped", dropped)
+	}
+	SetSecurityEventTags(span, events, md, opts...)
+}
+
+// FlushOnCancel flushes the accumulated events on the service entry span, as
+// Flush does, as soon as ctx is canceled, so that the events collected so far
+// are not lost when the stream is canceled before it ends. The returned function
+// stops watching ctx, flushing the events if ctx is already canceled or waiting
+// for the flush if it is in progress, and must be called when the stream ends,
+// before calling Flush.
+func (a *StreamEventAccumulator) FlushOnCancel(ctx context.Context, span ddtrace.Span, md map[string][]string, opts ...SecurityEventTagsOption) (stop func()) {
+	var (
+		stopped  = make(chan struct{})
+		exited   = make(chan struct{})
+		stopOnce sync.Once
+	)
+	go func() {
+		defer close(exited)
+		select {
+		case <-ctx.Done():
+			a.Flush(span, md, opts...)
+		case <-stopped:
+			// both cases may be ready when the stream ends right after ctx is
+			// canceled, the select then picking either one
+			if ctx.Err() != nil {
+				a.Flush(span, md, opts...)
+			}
+		}
+	}()
+	return func() {
+		stopOnce.Do(func() { close(stopped) })
+		<-exited
+	}
+}
+
+// ruleIDs returns the distinct ids of the rules which matched in the given
+// events, up to maxRuleIDs of them. Malformed events are skipped.
+func ruleIDs(events []json.RawMessage) []string {
+	var ids []string
+	seen := make(map[string]bool)
+	for _, event := range events {
+		var matches []struct {
+			Rule struct {
+				ID string `json:"id"`
+			} `json:"rule"`
+		}
+		if err := json.Unmarshal(event, &matches); err != nil {
+			log.Debug("appsec: skipping malformed security event while looking for rule ids: %v", err)
+			continue
+		}
+		for _, m := range matches {
+			if id := m.Rule.ID; id != "" && !seen[id] {
+				if len(ids) == maxRuleIDs {
+					return ids
+				}
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids
+}
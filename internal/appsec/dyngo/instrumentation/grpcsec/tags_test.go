@@ -6,10 +6,21 @@
 package grpcsec
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"math/rand"
 	"net"
+	"net/url"
+	"strings"
 	"testing"
+	"time"
+	"unicode/utf8"
 
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
@@ -118,6 +129,815 @@ func TestSetSecurityEventTags(t *testing.T) {
 	}
 }
 
+// wafEvent returns a security event as reported by the WAF when the rule with
+// the given id matched the given value of the gRPC request message.
+func wafEvent(ruleID, value string) json.RawMessage {
+	type (
+		parameter struct {
+			Address   string   `json:"address"`
+			KeyPath   []string `json:"key_path"`
+			Value     string   `json:"value"`
+			Highlight []string `json:"highlight"`
+		}
+		ruleMatch struct {
+			Operator      string      `json:"operator"`
+			OperatorValue string      `json:"operator_value"`
+			Parameters    []parameter `json:"parameters"`
+		}
+		rule struct {
+			ID   string            `json:"id"`
+			Name string            `json:"name"`
+			Tags map[string]string `json:"tags"`
+		}
+		event struct {
+			Rule        rule        `json:"rule"`
+			RuleMatches []ruleMatch `json:"rule_matches"`
+		}
+	)
+	// the WAF reports the events of a run as a JSON array
+	events, err := json.Marshal([]event{{
+		Rule: rule{
+			ID:   ruleID,
+			Name: "Test rule " + ruleID,
+			Tags: map[string]string{"type": "test", "category": "attack_attempt"},
+		},
+		RuleMatches: []ruleMatch{{
+			Operator:      "match_regex",
+			OperatorValue: value,
+			Parameters: []parameter{{
+				Address:   "grpc.server.request.message",
+				KeyPath:   []string{"0", "name"},
+				Value:     value,
+				Highlight: []string{value},
+			}},
+		}},
+	}})
+	if err != nil {
+		panic(err)
+	}
+	return events
+}
+
+func TestSetSecurityEventTagsWAFEvents(t *testing.T) {
+	for _, tc := range []struct {
+		name            string
+		events          []json.RawMessage
+		md              map[string][]string
+		expectedRuleIDs []string
+		expectedTags    map[string]interface{}
+	}{
+		{
+			name:   "one-event-one-metadata",
+			events: []json.RawMessage{wafEvent("crs-942-100", "1 OR 1=1")},
+			md: map[string][]string{
+				"user-agent": {"grpc-go/1.56.0"},
+			},
+			expectedRuleIDs: []string{"crs-942-100"},
+			expectedTags: map[string]interface{}{
+				"grpc.metadata.user-agent": "grpc-go/1.56.0",
+			},
+		},
+		{
+			name: "many-events-many-metadata",
+			events: []json.RawMessage{
+				wafEvent("crs-942-100", "1 OR 1=1"),
+				wafEvent("crs-932-160", "/bin/sh"),
+				wafEvent("ua0-600-55x", "Arachni/v1"),
+			},
+			md: map[string][]string{
+				"User-Agent":      {"Arachni/v1"},
+				"content-type":    {"application/grpc"},
+				"x-forwarded-for": {"1.2.3.4", "4.5.6.7"},
+				":authority":      {"localhost:50051"},
+				"x-custom":        {"not collected"},
+			},
+			expectedRuleIDs: []string{"crs-942-100", "crs-932-160", "ua0-600-55x"},
+			expectedTags: map[string]interface{}{
+				"grpc.metadata.user-agent":      "Arachni/v1",
+				"grpc.metadata.content-type":    "application/grpc",
+				"grpc.metadata.x-forwarded-for": "1.2.3.4,4.5.6.7",
+			},
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			var span MockSpan
+			require.NoError(t, setSecurityEventTags(&span, tc.events, tc.md))
+
+			// the events of every WAF run are concatenated into the triggers
+			var tag struct {
+				Triggers []struct {
+					Rule struct {
+						ID string `json:"id"`
+					} `json:"rule"`
+					RuleMatches []json.RawMessage `json:"rule_matches"`
+				} `json:"triggers"`
+			}
+			require.IsType(t, "", span.tags["_dd.appsec.json"])
+			require.NoError(t, json.Unmarshal([]byte(span.tags["_dd.appsec.json"].(string)), &tag))
+			var ruleIDs []string
+			for _, trigger := range tag.Triggers {
+				require.Len(t, trigger.RuleMatches, 1)
+				ruleIDs = append(ruleIDs, trigger.Rule.ID)
+			}
+			require.Equal(t, tc.expectedRuleIDs, ruleIDs)
+
+			expectedTags := map[string]interface{}{
+				"_dd.appsec.json": span.tags["_dd.appsec.json"],
+				"manual.keep":     true,
+				"appsec.event":    true,
+				"_dd.origin":      "appsec",
+			}
+			for k, v := range tc.expectedTags {
+				expectedTags[k] = v
+			}
+			require.Equal(t, expectedTags, span.tags)
+		})
+	}
+}
+
+func TestSetSecurityEventTagsWithEventSpan(t *testing.T) {
+	var (
+		entrySpan MockSpan
+		started   []*MockSpan
+	)
+	startSpan := func(operationName string, opts ...ddtrace.StartSpanOption) ddtrace.Span {
+		require.Equal(t, EventSpanName, operationName)
+		var cfg ddtrace.StartSpanConfig
+		for _, opt := range opts {
+			opt(&cfg)
+		}
+		span := &MockSpan{parent: cfg.Parent}
+		started = append(started, span)
+		return span
+	}
+
+	events := []json.RawMessage{json.RawMessage(`["one","two"]`), json.RawMessage(`["three","four"]`)}
+	md := map[string][]string{"x-forwarded-for": {"1.2.3.4"}}
+	err := setSecurityEventTags(&entrySpan, events, md, WithEventSpan(startSpan))
+	require.NoError(t, err)
+
+	require.Len(t, started, 1)
+	eventSpan := started[0]
+	require.Equal(t, MockSpanContext{span: &entrySpan}, eventSpan.parent)
+	require.True(t, eventSpan.finished)
+	require.Equal(t, map[string]interface{}{
+		"_dd.appsec.json": `{"triggers":["one","two","three","four"]}`,
+	}, eventSpan.tags)
+
+	// the service entry span keeps the tags marking and keeping the trace
+	require.Equal(t, map[string]interface{}{
+		"manual.keep":                   true,
+		"appsec.event":                  true,
+		"_dd.origin":                    "appsec",
+		"grpc.metadata.x-forwarded-for": "1.2.3.4",
+	}, entrySpan.tags)
+	require.False(t, entrySpan.finished)
+
+	t.Run("truncated", func(t *testing.T) {
+		var entrySpan MockSpan
+		started = nil
+		err := setSecurityEventTags(&entrySpan, events, nil, WithEventSpan(startSpan), WithMaxEventSize(32))
+		require.NoError(t, err)
+
+		require.Len(t, started, 1)
+		require.Contains(t, started[0].tags, "_dd.appsec.json")
+		require.Contains(t, started[0].tags, "_dd.appsec.json.truncated")
+		require.NotContains(t, entrySpan.tags, "_dd.appsec.json")
+		require.NotContains(t, entrySpan.tags, "_dd.appsec.json.truncated")
+		require.Equal(t, true, entrySpan.tags["appsec.event"])
+	})
+}
+
+func TestSetSecurityEventTagsWithMessageSizes(t *testing.T) {
+	events := []json.RawMessage{json.RawMessage(`["one","two"]`)}
+	for _, tc := range []struct {
+		name                      string
+		requestSize, responseSize int
+		expectedTags              map[string]interface{}
+	}{
+		{
+			name:         "both-sizes",
+			requestSize:  1024,
+			responseSize: 16,
+			expectedTags: map[string]interface{}{"grpc.request.length": 1024, "grpc.response.length": 16},
+		},
+		{
+			name:         "unknown-response-size",
+			requestSize:  1024,
+			expectedTags: map[string]interface{}{"grpc.request.length": 1024},
+		},
+		{
+			name: "unknown-sizes",
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			var span MockSpan
+			err := setSecurityEventTags(&span, events, nil, WithMessageSizes(tc.requestSize, tc.responseSize))
+			require.NoError(t, err)
+			for _, tag := range []string{"grpc.request.length", "grpc.response.length"} {
+				if v, ok := tc.expectedTags[tag]; ok {
+					require.Equal(t, v, span.tags[tag])
+				} else {
+					require.NotContains(t, span.tags, tag)
+				}
+			}
+		})
+	}
+}
+
+func TestSetSecurityEventTagsWithRuleIDs(t *testing.T) {
+	t.Run("distinct-rule-ids", func(t *testing.T) {
+		var span MockSpan
+		events := []json.RawMessage{
+			wafEvent("crs-942-100", "1 OR 1=1"),
+			wafEvent("crs-932-160", "/bin/sh"),
+			wafEvent("crs-942-100", "2 OR 2=2"),
+		}
+		require.NoError(t, setSecurityEventTags(&span, events, nil, WithRuleIDs()))
+		require.Equal(t, "crs-942-100,crs-932-160", span.tags["appsec.rule_ids"])
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		var span MockSpan
+		events := []json.RawMessage{wafEvent("crs-942-100", "1 OR 1=1")}
+		require.NoError(t, setSecurityEventTags(&span, events, nil))
+		require.NotContains(t, span.tags, "appsec.rule_ids")
+	})
+
+	t.Run("malformed-event", func(t *testing.T) {
+		ids := ruleIDs([]json.RawMessage{
+			json.RawMessage(`{"not":"an array"}`),
+			wafEvent("crs-942-100", "1 OR 1=1"),
+		})
+		require.Equal(t, []string{"crs-942-100"}, ids)
+	})
+
+	t.Run("capped", func(t *testing.T) {
+		var events []json.RawMessage
+		for i := 0; i < maxRuleIDs+10; i++ {
+			events = append(events, wafEvent(fmt.Sprintf("rule-%d", i), "value"))
+		}
+		require.Len(t, ruleIDs(events), maxRuleIDs)
+	})
+}
+
+func TestSetSecurityEventTagsWithMessageIndex(t *testing.T) {
+	var (
+		counter StreamMessageCounter
+		spans   []*MockSpan
+	)
+	// every message of the stream gets its own event span
+	messages := []string{"hello", "world", "1 OR 1=1"}
+	for _, msg := range messages {
+		index := counter.Next()
+		if msg != "1 OR 1=1" {
+			continue
+		}
+		var entrySpan MockSpan
+		startSpan := func(_ string, _ ...ddtrace.StartSpanOption) ddtrace.Span {
+			span := &MockSpan{}
+			spans = append(spans, span)
+			return span
+		}
+		events := []json.RawMessage{wafEvent("crs-942-100", msg)}
+		err := setSecurityEventTags(&entrySpan, events, nil, WithEventSpan(startSpan), WithMessageIndex(index))
+		require.NoError(t, err)
+	}
+
+	require.Len(t, spans, 1)
+	require.Equal(t, 2, spans[0].tags["grpc.message_index"])
+
+	// the tag is not set by default
+	var span MockSpan
+	require.NoError(t, setSecurityEventTags(&span, []json.RawMessage{wafEvent("crs-942-100", "1 OR 1=1")}, nil))
+	require.NotContains(t, span.tags, "grpc.message_index")
+}
+
+func TestSecurityEventTagsBuilder(t *testing.T) {
+	var b SecurityEventTagsBuilder
+	// first interceptor layer
+	b.AddEvents(wafEvent("crs-942-100", "1 OR 1=1"))
+	b.AddMetadata(map[string][]string{"x-forwarded-for": {"1.2.3.4"}})
+	// second interceptor layer
+	b.AddEvents(wafEvent("crs-932-160", "/bin/sh"))
+	b.AddMetadata(map[string][]string{"x-forwarded-for": {"4.5.6.7"}, "user-agent": {"grpc-go/1.56.0"}})
+
+	var span MockSpan
+	b.Apply(&span, WithRuleIDs())
+
+	var appsecJSON struct {
+		Triggers []json.RawMessage `json:"triggers"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(span.tags["_dd.appsec.json"].(string)), &appsecJSON))
+	require.Len(t, appsecJSON.Triggers, 2)
+	require.Equal(t, "crs-942-100,crs-932-160", span.tags["appsec.rule_ids"])
+	require.Equal(t, "1.2.3.4,4.5.6.7", span.tags["grpc.metadata.x-forwarded-for"])
+	require.Equal(t, "grpc-go/1.56.0", span.tags["grpc.metadata.user-agent"])
+
+	t.Run("no-events", func(t *testing.T) {
+		var b SecurityEventTagsBuilder
+		b.AddMetadata(map[string][]string{"user-agent": {"grpc-go/1.56.0"}})
+		var span MockSpan
+		b.Apply(&span)
+		require.Empty(t, span.tags)
+	})
+}
+
+func TestStreamEventAccumulator(t *testing.T) {
+	triggers := func(t *testing.T, span *MockSpan) []json.RawMessage {
+		var appsecJSON struct {
+			Triggers []json.RawMessage `json:"triggers"`
+		}
+		value, ok := span.tags["_dd.appsec.json"].(string)
+		require.True(t, ok, "the security events were not flushed")
+		require.NoError(t, json.Unmarshal([]byte(value), &appsecJSON))
+		return appsecJSON.Triggers
+	}
+
+	var a StreamEventAccumulator
+	// three WAF runs, the second one being added last
+	a.AddRun(0, wafEvent("crs-942-100", "1 OR 1=1"))
+	a.AddRun(2, wafEvent("crs-932-160", "/bin/sh"), wafEvent("crs-941-110", "<script>"))
+	a.AddRun(1, wafEvent("crs-913-110", "acunetix"))
+
+	var span MockSpan
+	a.Flush(&span, map[string][]string{"user-agent": {"grpc-go/1.56.0"}}, WithRuleIDs())
+	require.Len(t, triggers(t, &span), 4)
+	require.Equal(t, "crs-942-100,crs-913-110,crs-932-160,crs-941-110", span.tags["appsec.rule_ids"])
+	require.Equal(t, "grpc-go/1.56.0", span.tags["grpc.metadata.user-agent"])
+	require.NotContains(t, span.tags, "_dd.appsec.events_dropped")
+
+	// the events are flushed once
+	a.AddRun(3, wafEvent("crs-942-100", "1 OR 1=1"))
+	var other MockSpan
+	a.Flush(&other, nil)
+	require.Empty(t, other.tags)
+
+	t.Run("no-events", func(t *testing.T) {
+		var a StreamEventAccumulator
+		a.AddRun(0)
+		var span MockSpan
+		a.Flush(&span, nil)
+		require.Empty(t, span.tags)
+	})
+
+	t.Run("max-events", func(t *testing.T) {
+		var a StreamEventAccumulator
+		for i := 0; i < maxStreamEvents+10; i++ {
+			a.AddRun(i, wafEvent("crs-942-100", fmt.Sprint(i)))
+		}
+		var span MockSpan
+		a.Flush(&span, nil)
+		require.Len(t, triggers(t, &span), maxStreamEvents)
+		require.Equal(t, 10, span.tags["_dd.appsec.events_dropped"])
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		var a StreamEventAccumulator
+		ctx, cancel := context.WithCancel(context.Background())
+		var span MockSpan
+		stop := a.FlushOnCancel(ctx, &span, nil)
+		a.AddRun(0, wafEvent("crs-942-100", "1 OR 1=1"))
+		a.AddRun(1, wafEvent("crs-932-160", "/bin/sh"))
+		// the stream ends right after being canceled, racing with the watching
+		// goroutine, which must not lose the events either way
+		cancel()
+		stop()
+		require.Len(t, triggers(t, &span), 2)
+
+		// the stream end doesn't flush again
+		span.tags = nil
+		a.Flush(&span, nil)
+		require.Empty(t, span.tags)
+	})
+
+	t.Run("cancel-before-end", func(t *testing.T) {
+		var a StreamEventAccumulator
+		ctx, cancel := context.WithCancel(context.Background())
+		var span MockSpan
+		stop := a.FlushOnCancel(ctx, &span, nil)
+		a.AddRun(0, wafEvent("crs-942-100", "1 OR 1=1"))
+		cancel()
+		require.Eventually(t, func() bool {
+			a.mu.Lock()
+			defer a.mu.Unlock()
+			return a.flushed
+		}, time.Second, time.Millisecond)
+		stop()
+		require.Len(t, triggers(t, &span), 1)
+	})
+
+	t.Run("end-before-cancel", func(t *testing.T) {
+		var a StreamEventAccumulator
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		var span MockSpan
+		stop := a.FlushOnCancel(ctx, &span, nil)
+		a.AddRun(0, wafEvent("crs-942-100", "1 OR 1=1"))
+		stop()
+		a.Flush(&span, nil)
+		require.Len(t, triggers(t, &span), 1)
+	})
+}
+
+func TestSetSecurityEventTagsWithMethod(t *testing.T) {
+	events := []json.RawMessage{json.RawMessage(`["one","two"]`)}
+	for _, tc := range []struct {
+		name         string
+		fullMethod   string
+		expectedTags map[string]interface{}
+	}{
+		{
+			name:       "well-formed",
+			fullMethod: "/grpc.testing.Fixture/Ping",
+			expectedTags: map[string]interface{}{
+				"rpc.service":          "grpc.testing.Fixture",
+				"rpc.method":           "Ping",
+				"rpc.grpc.full_method": "/grpc.testing.Fixture/Ping",
+				"http.route":           "/grpc.testing.Fixture/Ping",
+			},
+		},
+		{name: "no-leading-slash", fullMethod: "grpc.testing.Fixture/Ping"},
+		{name: "no-method", fullMethod: "/grpc.testing.Fixture"},
+		{name: "empty-method", fullMethod: "/grpc.testing.Fixture/"},
+		{name: "empty-service", fullMethod: "//Ping"},
+		{name: "too-many-parts", fullMethod: "/grpc.testing.Fixture/Ping/Pong"},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			var span MockSpan
+			err := setSecurityEventTags(&span, events, nil, WithMethod(tc.fullMethod))
+			require.NoError(t, err)
+			for _, tag := range []string{"rpc.service", "rpc.method", "rpc.grpc.full_method", "http.route"} {
+				if v, ok := tc.expectedTags[tag]; ok {
+					require.Equal(t, v, span.tags[tag])
+				} else {
+					require.NotContains(t, span.tags, tag)
+				}
+			}
+		})
+	}
+}
+
+func TestSetSecurityEventTagsWithMethodRateLimit(t *testing.T) {
+	now := time.Now()
+	limiter := NewMethodRateLimiter(3)
+	limiter.now = func() time.Time { return now }
+
+	events := []json.RawMessage{json.RawMessage(`["one","two"]`)}
+	setTags := func(method string) *MockSpan {
+		var span MockSpan
+		err := setSecurityEventTags(&span, events, nil, WithMethodRateLimit(limiter, method))
+		require.NoError(t, err)
+		return &span
+	}
+	var tagged, limited int
+	for i := 0; i < 10; i++ {
+		span := setTags("/grpc.testing.Fixture/Ping")
+		if _, ok := span.tags["_dd.appsec.json"]; ok {
+			require.NotContains(t, span.tags, "_dd.appsec.rate_limited")
+			tagged++
+		} else {
+			require.Equal(t, map[string]interface{}{"_dd.appsec.rate_limited": true}, span.tags)
+			limited++
+		}
+	}
+	require.Equal(t, 3, tagged)
+	require.Equal(t, 7, limited)
+
+	// the limit is per method
+	require.Contains(t, setTags("/grpc.testing.Fixture/StreamPing").tags, "_dd.appsec.json")
+
+	// and per second
+	now = now.Add(time.Second)
+	require.Contains(t, setTags("/grpc.testing.Fixture/Ping").tags, "_dd.appsec.json")
+
+	t.Run("no-limit", func(t *testing.T) {
+		for _, perSecond := range []int{0, -1} {
+			limiter := NewMethodRateLimiter(perSecond)
+			for i := 0; i < 10; i++ {
+				require.True(t, limiter.Allow("/grpc.testing.Fixture/Ping"))
+			}
+		}
+	})
+
+	t.Run("methods", func(t *testing.T) {
+		now := time.Now()
+		limiter := NewMethodRateLimiter(1)
+		limiter.now = func() time.Time { return now }
+
+		// the methods past maxMethodWindows share the same limit
+		for i := 0; i < maxMethodWindows; i++ {
+			require.True(t, limiter.Allow(fmt.Sprintf("/grpc.testing.Fixture/Method%d", i)))
+		}
+		require.True(t, limiter.Allow("/grpc.testing.Fixture/Other1"))
+		require.False(t, limiter.Allow("/grpc.testing.Fixture/Other2"))
+		require.Len(t, limiter.windows, maxMethodWindows)
+
+		// the windows of the methods not seen within the last second are dropped
+		now = now.Add(time.Second)
+		require.True(t, limiter.Allow("/grpc.testing.Fixture/Ping"))
+		require.Len(t, limiter.windows, 1)
+	})
+}
+
+func TestSetSecurityEventTagsWithRequestSchema(t *testing.T) {
+	events := []json.RawMessage{json.RawMessage(`["one","two"]`)}
+	decode := func(t *testing.T, tag interface{}) interface{} {
+		gzipped, err := base64.StdEncoding.DecodeString(tag.(string))
+		require.NoError(t, err)
+		r, err := gzip.NewReader(bytes.NewReader(gzipped))
+		require.NoError(t, err)
+		var schema interface{}
+		require.NoError(t, json.NewDecoder(r).Decode(&schema))
+		return schema
+	}
+
+	t.Run("schema", func(t *testing.T) {
+		schema := []interface{}{map[string]interface{}{
+			"name": []interface{}{8.0},
+			"ids":  []interface{}{[]interface{}{[]interface{}{4.0}}, map[string]interface{}{"len": 2.0}},
+		}}
+		var span MockSpan
+		require.NoError(t, setSecurityEventTags(&span, events, nil, WithRequestSchema(schema)))
+		require.Contains(t, span.tags, "_dd.appsec.s.req.body")
+		require.LessOrEqual(t, len(span.tags["_dd.appsec.s.req.body"].(string)), maxRequestSchemaSize)
+		require.Equal(t, schema, decode(t, span.tags["_dd.appsec.s.req.body"]))
+	})
+
+	t.Run("too-large-schema", func(t *testing.T) {
+		rnd := rand.New(rand.NewSource(0))
+		schema := make(map[string]interface{})
+		for i := 0; i < 10000; i++ {
+			schema[fmt.Sprintf("field_%x", rnd.Uint64())] = []interface{}{rnd.Intn(16)}
+		}
+		var span MockSpan
+		require.NoError(t, setSecurityEventTags(&span, events, nil, WithRequestSchema(schema)))
+		require.NotContains(t, span.tags, "_dd.appsec.s.req.body")
+		require.Contains(t, span.tags, "_dd.appsec.json")
+	})
+
+	t.Run("unencodable-schema", func(t *testing.T) {
+		var span MockSpan
+		require.Error(t, setSecurityEventTags(&span, events, nil, WithRequestSchema(make(chan int))))
+		require.NotContains(t, span.tags, "_dd.appsec.s.req.body")
+	})
+}
+
+func TestSetSecurityEventTagsWithMaxEventSize(t *testing.T) {
+	trigger := func(value string) string {
+		return fmt.Sprintf(`{"rule":{"id":"ua0-600-55x"},"rule_matches":[{"parameters":[{"value":%q}]}]}`, value)
+	}
+	// multibyte characters around the cap
+	value := strings.Repeat("é", 100) + strings.Repeat("日本語", 100)
+
+	t.Run("fitting", func(t *testing.T) {
+		var span MockSpan
+		events := []json.RawMessage{json.RawMessage("[" + trigger("ok") + "]")}
+		err := setSecurityEventTags(&span, events, nil, WithMaxEventSize(1000))
+		require.NoError(t, err)
+		require.Equal(t, `{"triggers":[`+trigger("ok")+`]}`, span.tags["_dd.appsec.json"])
+		require.NotContains(t, span.tags, "_dd.appsec.json.truncated")
+	})
+
+	t.Run("triggers", func(t *testing.T) {
+		var span MockSpan
+		events := []json.RawMessage{
+			json.RawMessage("[" + trigger("ok") + "]"),
+			json.RawMessage("[" + trigger(value) + "]"),
+		}
+		err := setSecurityEventTags(&span, events, nil, WithMaxEventSize(500))
+		require.NoError(t, err)
+		tag := span.tags["_dd.appsec.json"].(string)
+		require.LessOrEqual(t, len(tag), 500)
+		require.JSONEq(t, `{"triggers":[`+trigger("ok")+`]}`, tag)
+		require.Equal(t, "triggers", span.tags["_dd.appsec.json.truncated"])
+	})
+
+	t.Run("bytes", func(t *testing.T) {
+		for max := 300; max < 310; max++ {
+			var span MockSpan
+			events := []json.RawMessage{json.RawMessage("[" + trigger(value) + "]")}
+			err := setSecurityEventTags(&span, events, nil, WithMaxEventSize(max))
+			require.NoError(t, err)
+			tag := span.tags["_dd.appsec.json"].(string)
+			require.LessOrEqual(t, len(tag), max)
+			require.Greater(t, len(tag), max-utf8.UTFMax)
+			require.True(t, utf8.ValidString(tag))
+			require.Equal(t, "bytes", span.tags["_dd.appsec.json.truncated"])
+		}
+	})
+}
+
+func TestSetSecurityEventTagsWithMonitoringMode(t *testing.T) {
+	events := []json.RawMessage{json.RawMessage(`["one","two"]`)}
+	t.Run("monitoring", func(t *testing.T) {
+		var span MockSpan
+		err := setSecurityEventTags(&span, events, nil, WithMonitoringMode())
+		require.NoError(t, err)
+		require.Equal(t, "true", span.tags["_dd.appsec.monitored"])
+	})
+
+	t.Run("blocking", func(t *testing.T) {
+		var span MockSpan
+		err := setSecurityEventTags(&span, events, nil)
+		require.NoError(t, err)
+		require.NotContains(t, span.tags, "_dd.appsec.monitored")
+	})
+}
+
+func TestSetSecurityEventTagsWithMatchedParams(t *testing.T) {
+	events := []json.RawMessage{json.RawMessage(`["one","two"]`)}
+	t.Run("params", func(t *testing.T) {
+		var span MockSpan
+		err := setSecurityEventTags(&span, events, nil, WithMatchedParams(
+			MatchedParam{Path: "query.filter", Value: "1' OR '1'='1"},
+			MatchedParam{Path: "user.password", Value: "hunter2"},
+			MatchedParam{Path: "query.filter", Value: "1' OR '1'='1"},
+		))
+		require.NoError(t, err)
+		require.Equal(t, "query.filter=1' OR '1'='1,user.password=<redacted>", span.tags["appsec.matched_params"])
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		var params []MatchedParam
+		for i := 0; i < 100; i++ {
+			params = append(params, MatchedParam{Path: fmt.Sprintf("items.%d", i), Value: strings.Repeat("é", 100)})
+		}
+		tag := matchedParamsTag(params)
+		require.LessOrEqual(t, len(tag), maxMatchedParamsSize)
+		require.True(t, utf8.ValidString(tag))
+		entries := strings.Split(tag, ",")
+		require.Less(t, len(entries), 100)
+		require.Equal(t, "items.0="+strings.Repeat("é", maxMatchedParamValueSize/2), entries[0])
+	})
+
+	t.Run("none", func(t *testing.T) {
+		var span MockSpan
+		err := setSecurityEventTags(&span, events, nil)
+		require.NoError(t, err)
+		require.NotContains(t, span.tags, "appsec.matched_params")
+	})
+}
+
+func TestSetSecurityEventTagsWithPeerTLS(t *testing.T) {
+	events := []json.RawMessage{json.RawMessage(`["one","two"]`)}
+	t.Run("peer", func(t *testing.T) {
+		var span MockSpan
+		peer := PeerTLSFromCertificate(&x509.Certificate{
+			Subject: pkix.Name{CommonName: "client", Organization: []string{"Datadog"}},
+			URIs: []*url.URL{
+				{Scheme: "https", Host: "example.org"},
+				{Scheme: "spiffe", Host: "example.org", Path: "/service"},
+			},
+		})
+		err := setSecurityEventTags(&span, events, nil, WithPeerTLS(peer))
+		require.NoError(t, err)
+		require.Equal(t, "CN=client,O=Datadog", span.tags["tls.peer.subject"])
+		require.Equal(t, "spiffe://example.org/service", span.tags["tls.peer.spiffe_id"])
+	})
+
+	t.Run("none", func(t *testing.T) {
+		var span MockSpan
+		err := setSecurityEventTags(&span, events, nil, WithPeerTLS(PeerTLSFromCertificate(nil)))
+		require.NoError(t, err)
+		require.NotContains(t, span.tags, "tls.peer.subject")
+		require.NotContains(t, span.tags, "tls.peer.spiffe_id")
+	})
+}
+
+func TestTruncateUTF8(t *testing.T) {
+	for _, tc := range []struct {
+		s        string
+		max      int
+		expected string
+	}{
+		{s: "abc", max: 5, expected: "abc"},
+		{s: "abc", max: 2, expected: "ab"},
+		{s: "aé", max: 2, expected: "a"},
+		{s: "aé", max: 3, expected: "aé"},
+		{s: "日本", max: 5, expected: "日"},
+		{s: "日本", max: 2, expected: ""},
+		{s: "abc", max: 0, expected: ""},
+	} {
+		require.Equal(t, tc.expected, truncateUTF8(tc.s, tc.max), "%q[:%d]", tc.s, tc.max)
+	}
+}
+
+func TestSetSecurityEventTagsNilSpan(t *testing.T) {
+	events := []json.RawMessage{json.RawMessage(`["one","two"]`)}
+	md := map[string][]string{"x-forwarded-for": {"1.2.3.4"}}
+	require.NotPanics(t, func() {
+		SetSecurityEventTags(nil, events, md)
+	})
+	require.NoError(t, setSecurityEventTags(nil, events, md))
+}
+
+func TestSetWAFTimingTags(t *testing.T) {
+	var span MockSpan
+	SetWAFTimingTags(&span, 150*time.Microsecond, 1500*time.Nanosecond+200*time.Microsecond)
+	require.Equal(t, map[string]interface{}{
+		"_dd.appsec.waf.duration":     150.0,
+		"_dd.appsec.waf.duration_ext": 201.5,
+	}, span.tags)
+
+	require.NotPanics(t, func() {
+		SetWAFTimingTags(nil, time.Microsecond, time.Microsecond)
+	})
+}
+
+func TestSetRequestMetadataTags(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		md           map[string][]string
+		expectedTags map[string]interface{}
+	}{
+		{
+			name: "no-metadata",
+		},
+		{
+			name: "baseline-metadata",
+			md: map[string][]string{
+				"user-agent":   {"grpc-go/1.56.0"},
+				"grpc-timeout": {"100m"},
+				"content-type": {"application/grpc"},
+			},
+			expectedTags: map[string]interface{}{
+				"grpc.metadata.user-agent":   "grpc-go/1.56.0",
+				"grpc.metadata.grpc-timeout": "100m",
+				"grpc.metadata.content-type": "application/grpc",
+			},
+		},
+		{
+			name: "mixed-case-and-other-metadata",
+			md: map[string][]string{
+				"User-Agent":      {"grpc-go/1.56.0"},
+				"x-forwarded-for": {"1.2.3.4"},
+				":authority":      {"something"},
+			},
+			expectedTags: map[string]interface{}{
+				"grpc.metadata.user-agent": "grpc-go/1.56.0",
+			},
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			var span MockSpan
+			SetRequestMetadataTags(&span, tc.md)
+			require.Equal(t, tc.expectedTags, span.tags)
+		})
+	}
+
+	require.NotPanics(t, func() {
+		SetRequestMetadataTags(nil, map[string][]string{"user-agent": {"grpc-go/1.56.0"}})
+	})
+}
+
+func TestSetBlockDecisionTags(t *testing.T) {
+	t.Run("block-by-ip", func(t *testing.T) {
+		var span MockSpan
+		SetBlockDecisionTags(&span, BlockDecision{
+			Blocked:  true,
+			ClientIP: netip.MustParseAddr("1.2.3.4"),
+			ListID:   "blocked_ips",
+		})
+		require.Equal(t, map[string]interface{}{
+			"appsec.blocked":         true,
+			"actor.ip":               "1.2.3.4",
+			"appsec.blocked.list_id": "blocked_ips",
+		}, span.tags)
+	})
+
+	t.Run("allow", func(t *testing.T) {
+		var span MockSpan
+		SetBlockDecisionTags(&span, BlockDecision{
+			ClientIP: netip.MustParseAddr("1.2.3.4"),
+			ListID:   "blocked_ips",
+		})
+		require.Empty(t, span.tags)
+	})
+
+	require.NotPanics(t, func() {
+		SetBlockDecisionTags(nil, BlockDecision{Blocked: true})
+	})
+}
+
+func TestSetUserID(t *testing.T) {
+	var span MockSpan
+	SetUserID(&span, "user-42")
+	require.Equal(t, map[string]interface{}{"usr.id": "user-42"}, span.tags)
+
+	span = MockSpan{}
+	SetUserID(&span, "")
+	require.Empty(t, span.tags)
+
+	require.NotPanics(t, func() {
+		SetUserID(nil, "user-42")
+	})
+}
+
 func TestClientIP(t *testing.T) {
 	for _, tc := range []struct {
 		name             string
@@ -168,6 +988,7 @@ func TestClientIP(t *testing.T) {
 type MockSpan struct {
 	tags     map[string]interface{}
 	finished bool
+	parent   ddtrace.SpanContext
 }
 
 func (m *MockSpan) SetTag(key string, value interface{}) {
@@ -200,5 +1021,21 @@ func (m *MockSpan) Finish(_ ...ddtrace.FinishOption) {
 }
 
 func (m *MockSpan) Context() ddtrace.SpanContext {
+	return MockSpanContext{span: m}
+}
+
+type MockSpanContext struct {
+	span *MockSpan
+}
+
+func (MockSpanContext) SpanID() uint64 {
+	panic("unused")
+}
+
+func (MockSpanContext) TraceID() uint64 {
+	panic("unused")
+}
+
+func (MockSpanContext) ForeachBaggageItem(_ func(k, v string) bool) {
 	panic("unused")
 }
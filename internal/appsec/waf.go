@@ -358,6 +358,7 @@ func newGRPCWAFEventListener(handle *waf.Handle, addresses map[string]struct{},
 			atomic.AddUint32(&nbEvents, 1)
 			mu.Lock()
 			events = append(events, event)
+			op.SetMessageIndex(res.MessageIndex)
 			mu.Unlock()
 		}))
 